@@ -5,20 +5,30 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"sort"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	consul "github.com/hashicorp/consul/api"
+	"github.com/horockey/go-consul-instance-manager/clock"
+	"github.com/horockey/go-consul-instance-manager/internal/elector"
 	"github.com/horockey/go-consul-instance-manager/internal/healthchecker"
+	"github.com/horockey/go-consul-instance-manager/internal/model"
 	"github.com/horockey/go-consul-instance-manager/internal/pending_instances_holder"
+	"github.com/horockey/go-consul-instance-manager/internal/queue"
+	"github.com/horockey/go-consul-instance-manager/internal/retrier"
 	"github.com/horockey/go-toolbox/options"
 	"github.com/rs/zerolog"
 	"github.com/serialx/hashring"
-	"golang.org/x/exp/maps"
 )
 
+var ErrInstanceNotFound = errors.New("instance not found")
+
+var errSelfNotSet = errors.New("local instance name is not set, see WithSelf")
+
+// Deprecated: it is not used by the package. Typed data keys are constrained by Key.
 type Comparable interface{ comparable }
 
 type Client struct {
@@ -29,13 +39,60 @@ type Client struct {
 
 	appName   string
 	hashrings []*hashring.HashRing
+	// Hash funcs of hashrings, nil is for default one.
+	hashFuncs []hashring.HashFunc
+
+	self     string
+	selfLost bool
+
+	eventHandler func(Event)
+
+	ready chan struct{}
 
-	pih     *pending_instances_holder.PendingInstancesHolder
-	holdDur time.Duration
+	lifecycleMu sync.Mutex
+	state       ClientState
+	cancel      context.CancelFunc
+	done        chan struct{}
+	// Set, if client is closed before it was started.
+	closedBeforeStart bool
+	deregisterOnClose bool
+
+	pih             *pending_instances_holder.PendingInstancesHolder
+	holdDur         time.Duration
+	holdResolver    func(*Instance) time.Duration
+	maxAdaptiveHold time.Duration
+	recentDowns     []time.Time
+
+	tombstones         *pending_instances_holder.PendingInstancesHolder
+	tombstoneRetention time.Duration
+
+	degradedInHashrings bool
 
 	healthChecker *healthchecker.HealthChecker
 	pollInterval  time.Duration
-	hcOutChanSize uint
+	damping       healthchecker.DampingConfig
+
+	panicCfg          healthchecker.PanicConfig
+	panicking         bool
+	deferredEvictions []model.InstanceChange
+
+	maxStaleness time.Duration
+
+	clock clock.Clock
+
+	retrier     *retrier.Retrier
+	retryPolicy retrier.Policy
+
+	elector        *elector.Elector
+	leaderElection bool
+	leader         string
+	onLeaderGained func()
+	onLeaderLost   func()
+
+	sessionTTL time.Duration
+	lockDelay  time.Duration
+
+	leasePartitions uint
 
 	logger zerolog.Logger
 }
@@ -48,13 +105,20 @@ func NewClient(appName string, opts ...options.Option[Client]) (*Client, error)
 	}
 
 	client := Client{
-		cl:            cc,
-		appName:       appName,
-		instances:     map[string]*Instance{},
-		holdDur:       time.Second * 15,
-		pollInterval:  time.Second,
-		hcOutChanSize: 100,
-		hashrings:     []*hashring.HashRing{hashring.New([]string{})},
+		cl:                  cc,
+		appName:             appName,
+		instances:           map[string]*Instance{},
+		holdDur:             time.Second * 15,
+		tombstoneRetention:  time.Minute * 5,
+		degradedInHashrings: true,
+		pollInterval:        time.Second,
+		sessionTTL:          time.Second * 15,
+		lockDelay:           time.Second * 15,
+		leasePartitions:     256,
+		hashFuncs:           []hashring.HashFunc{nil},
+		clock:               clock.New(),
+		eventHandler:        func(Event) {},
+		ready:               make(chan struct{}),
 		logger: zerolog.New(zerolog.ConsoleWriter{
 			Out:        os.Stdout,
 			TimeFormat: time.RFC3339,
@@ -65,129 +129,805 @@ func NewClient(appName string, opts ...options.Option[Client]) (*Client, error)
 		return nil, fmt.Errorf("applying opts: %w", err)
 	}
 
-	client.pih, err = pending_instances_holder.New(client.holdDur)
-	if err != nil {
-		return nil, fmt.Errorf("creating PIH: %w", err)
+	if client.leaderElection && client.self == "" {
+		return nil, fmt.Errorf("leader election: %w", errSelfNotSet)
 	}
 
-	client.healthChecker = healthchecker.New(
-		client.cl,
-		client.appName,
-		client.pollInterval,
-		client.hcOutChanSize,
-		client.logger,
-	)
+	if client.deregisterOnClose && client.self == "" {
+		return nil, fmt.Errorf("deregister on close: %w", errSelfNotSet)
+	}
+
+	client.hashrings = client.newHashrings(nil)
+	client.retrier = retrier.New(client.retryPolicy, client.clock, client.logger)
 
 	return &client, nil
 }
 
+// Starts client and blocks until ctx is done or Close is called.
+// Every start is performed with fresh internal state,
+// so client may be started again after it is stopped.
+// If client was closed before it was ever started, Start returns immediately.
 func (cl *Client) Start(ctx context.Context) (resErr error) {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done, err := cl.prepareStart(cancel)
+	if err != nil {
+		return err
+	}
+	if done == nil {
+		return nil
+	}
+	defer func() {
+		cl.lifecycleMu.Lock()
+		cl.state = ClientStateStopped
+		cl.lifecycleMu.Unlock()
+		cl.resetReady()
+		close(done)
+	}()
+
 	var wg sync.WaitGroup
-	defer wg.Wait()
+	errs := make(chan error, 4)
+	runComponent := func(name string, start func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := start(runCtx); err != nil && !errors.Is(err, context.Canceled) {
+				errs <- fmt.Errorf("running %s: %w", name, err)
+			}
+		}()
+	}
+
+	runComponent("healthchecker", cl.healthChecker.Start)
+	runComponent("PIH", cl.pih.Start)
+	runComponent("tombstones holder", cl.tombstones.Start)
+
+	var electorOut chan string
+	if cl.elector != nil {
+		electorOut = cl.elector.Out()
+		runComponent("elector", cl.elector.Start)
+	}
+	defer cl.setLeader("")
+
+	cl.lifecycleMu.Lock()
+	cl.state = ClientStateRunning
+	cl.lifecycleMu.Unlock()
+
+	for running := true; running; {
+		select {
+		case <-cl.healthChecker.Out().Notify():
+			cl.drainChanges()
+
+		case panicking := <-cl.healthChecker.Panics():
+			cl.handlePanic(panicking)
+
+		case <-cl.pih.Out().Notify():
+			evs := drainQueue(cl.pih.Out())
+			if cl.Panicking() {
+				cl.deferredEvictions = append(cl.deferredEvictions, evs...)
+				continue
+			}
+			cl.applyTimerInputs(evs, instanceInputHoldExpired)
+
+		case <-cl.tombstones.Out().Notify():
+			cl.applyTimerInputs(drainQueue(cl.tombstones.Out()), instanceInputRetentionExpired)
+
+		case leader := <-electorOut:
+			cl.setLeader(leader)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := cl.healthChecker.Start(ctx); err != nil && !errors.Is(err, context.Canceled) {
-			resErr = errors.Join(resErr, fmt.Errorf("running healthchecker: %w", err))
+		case err := <-errs:
+			resErr = errors.Join(resErr, err)
+			running = false
+
+		case <-runCtx.Done():
+			if ctx.Err() != nil {
+				resErr = errors.Join(resErr, fmt.Errorf("running context: %w", ctx.Err()))
+			}
+			running = false
 		}
-	}()
+	}
+
+	cancel()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		resErr = errors.Join(resErr, err)
+	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := cl.pih.Start(ctx); err != nil && !errors.Is(err, context.Canceled) {
-			resErr = errors.Join(resErr, fmt.Errorf("running PIH: %w", err))
+	return resErr
+}
+
+// Stops running client and waits until all its goroutines are finished or ctx is done.
+// If WithDeregisterOnClose is set, local instance is deregistered from consul.
+// Client, that was never started, is moved to stopped state and can not be started anymore,
+// so Start, called concurrently with Close, does not outlive it.
+func (cl *Client) Close(ctx context.Context) error {
+	cl.lifecycleMu.Lock()
+	if cl.state == ClientStateNew || cl.closedBeforeStart {
+		cl.state = ClientStateStopped
+		cl.closedBeforeStart = true
+		cl.lifecycleMu.Unlock()
+		return nil
+	}
+	cancel, done := cl.cancel, cl.done
+	cl.lifecycleMu.Unlock()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for client to stop: %w", ctx.Err())
+	}
+
+	if cl.deregisterOnClose {
+		if err := cl.DeregisterContext(ctx, cl.self); err != nil {
+			return fmt.Errorf("deregistering local instance: %w", err)
 		}
-	}()
+	}
 
-	for resErr == nil {
-		select {
-		case ev := <-cl.healthChecker.Out():
-			switch {
-			default:
-				cl.mu.Lock()
-				cl.instances[ev.Instance.Name] = &Instance{
-					name:    ev.Instance.Name,
-					address: ev.Instance.Address,
-					status:  InstanceStatusAlive,
-				}
-				for idx, hr := range cl.hashrings {
-					cl.hashrings[idx] = hr.AddNode(ev.Instance.Name)
-				}
-				cl.mu.Unlock()
-			case ev.IsDown:
-				cl.mu.Lock()
-				cl.instances[ev.Instance.Name] = &Instance{
-					name:    ev.Instance.Name,
-					address: ev.Instance.Address,
-					status:  InstanceStatusPending,
-				}
-				cl.mu.Unlock()
-
-				if err := cl.pih.Add(ev.Instance); err != nil {
-					cl.logger.Error().
-						Err(fmt.Errorf("adding instance to PIH: %w", err)).
-						Send()
-				}
+	return nil
+}
+
+// Get current lifecycle state of the client.
+func (cl *Client) State() ClientState {
+	cl.lifecycleMu.Lock()
+	defer cl.lifecycleMu.Unlock()
+
+	return cl.state
+}
+
+// Moves client to starting state and creates fresh internals.
+// Nil done channel is returned, if client is closed before start.
+func (cl *Client) prepareStart(cancel context.CancelFunc) (chan struct{}, error) {
+	cl.lifecycleMu.Lock()
+	defer cl.lifecycleMu.Unlock()
+
+	if cl.closedBeforeStart {
+		return nil, nil
+	}
+
+	if cl.state == ClientStateStarting || cl.state == ClientStateRunning {
+		return nil, errors.New("client is already running")
+	}
+
+	// Circuit breaker, opened by the previous run, is not inherited.
+	cl.retrier.ResetBreaker()
+
+	pih, err := pending_instances_holder.New(cl.holdDur, cl.clock)
+	if err != nil {
+		return nil, fmt.Errorf("creating PIH: %w", err)
+	}
+
+	tombstones, err := pending_instances_holder.New(cl.tombstoneRetention, cl.clock)
+	if err != nil {
+		return nil, fmt.Errorf("creating tombstones holder: %w", err)
+	}
+
+	cl.state = ClientStateStarting
+	cl.cancel = cancel
+	cl.done = make(chan struct{})
+
+	cl.pih = pih
+	cl.tombstones = tombstones
+	cl.healthChecker = healthchecker.New(
+		cl.cl.Catalog(),
+		cl.retrier,
+		cl.appName,
+		cl.pollInterval,
+		cl.damping,
+		cl.panicCfg,
+		cl.clock,
+		cl.logger,
+	)
+
+	cl.elector = nil
+	if cl.leaderElection {
+		cl.elector = elector.New(
+			cl.cl,
+			cl.retrier,
+			cl.appName+"/leader",
+			cl.self,
+			cl.sessionTTL,
+			cl.clock,
+			cl.logger,
+		)
+	}
+
+	cl.mu.Lock()
+	cl.instances = map[string]*Instance{}
+	cl.hashrings = cl.newHashrings(nil)
+	cl.recentDowns = nil
+	cl.panicking = false
+	cl.deferredEvictions = nil
+	cl.mu.Unlock()
+
+	cl.selfLost = false
+
+	return cl.done, nil
+}
+
+// Renews ready channel of stopped client, so readiness of the previous run is not seen by waiters of the next one.
+// If previous run did not get ready, its waiters keep waiting for the next one.
+func (cl *Client) resetReady() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	select {
+	case <-cl.ready:
+		cl.ready = make(chan struct{})
+	default:
+	}
+}
+
+// Applies changes of the scan as a single topology update
+// and marks client ready after the first one.
+func (cl *Client) handleChangeSet(cs model.ChangeSet) {
+	inputs := make([]inputEvent, 0, len(cs.Changes))
+	for _, ch := range cs.Changes {
+		in := instanceInputPassing
+		switch {
+		case ch.IsDown:
+			in = instanceInputFailing
+		case ch.Instance.Degraded:
+			in = instanceInputWarning
+		}
+		inputs = append(inputs, inputEvent{src: ch.Instance, in: in})
+	}
+
+	cl.applyInputs(inputs)
+	cl.handleScan(cs.ScannedAt)
+
+	cl.mu.Lock()
+	select {
+	case <-cl.ready:
+	default:
+		close(cl.ready)
+	}
+	cl.mu.Unlock()
+}
+
+func (cl *Client) drainChanges() {
+	for cs, ok := cl.healthChecker.Out().Pop(); ok; cs, ok = cl.healthChecker.Out().Pop() {
+		cl.handleChangeSet(cs)
+	}
+}
+
+// Applies expired timers of PIH or tombstones holder as a single topology update.
+func (cl *Client) applyTimerInputs(evs []model.InstanceChange, in instanceInput) {
+	inputs := make([]inputEvent, 0, len(evs))
+	for _, ev := range evs {
+		inputs = append(inputs, inputEvent{src: ev.Instance, in: in, gen: ev.Generation})
+	}
+
+	cl.applyInputs(inputs)
+}
+
+// Takes all pending values of the queue.
+func drainQueue[K comparable, V any](q *queue.Queue[K, V]) []V {
+	vals := []V{}
+	for v, ok := q.Pop(); ok; v, ok = q.Pop() {
+		vals = append(vals, v)
+	}
+
+	return vals
+}
+
+// Marks all alive instances as seen healthy by the scan.
+func (cl *Client) handleScan(scannedAt time.Time) {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	for _, ins := range cl.instances {
+		if ins.status == InstanceStatusAlive {
+			ins.lastSeenHealthy.Store(scannedAt.UnixNano())
+		}
+	}
+}
+
+// Input of instance state machine.
+type inputEvent struct {
+	src model.Instance
+	in  instanceInput
+	gen uint64
+}
+
+// Transition, staged for publishing.
+type stagedTransition struct {
+	src  model.Instance
+	prev *Instance
+	ins  *Instance
+	tr   instanceTransition
+	hold time.Duration
+}
+
+// Same as applyInputs for a single input.
+func (cl *Client) applyInput(src model.Instance, in instanceInput, gen uint64) {
+	cl.applyInputs([]inputEvent{{src: src, in: in, gen: gen}})
+}
+
+// Moves instances to the next states and performs side effects of the transitions:
+// updates hashrings, (un)schedules timers and notifies event handler.
+// All transitions are published as a single topology update with at most one rebuild of hashrings.
+// Topology is modified by the caller goroutine only, so it is read here without lock.
+func (cl *Client) applyInputs(inputs []inputEvent) {
+	now := cl.clock.Now()
+
+	// Staged instances by name, nil means forgotten instance.
+	staged := make(map[string]*Instance, len(inputs))
+	lookup := func(name string) *Instance {
+		if ins, found := staged[name]; found {
+			return ins
+		}
+		return cl.instances[name]
+	}
+
+	transitions := make([]stagedTransition, 0, len(inputs))
+	ringChanged := false
+
+	for _, input := range inputs {
+		src := input.src
+		prev := lookup(src.Name)
+		tr, err := transit(prev, input.in, input.gen)
+		if err != nil {
+			if !errors.Is(err, errStaleInput) {
+				cl.logger.Warn().
+					Err(fmt.Errorf("applying input for %s: %w", src.Name, err)).
+					Send()
 			}
+			continue
+		}
+
+		if prev != nil && (input.in == instanceInputHoldExpired || input.in == instanceInputRetentionExpired) {
+			// Timer inputs carry instance, captured at scheduling.
+			src = prev.source()
+		}
 
-		case ev := <-cl.pih.Out():
-			cl.mu.Lock()
-			delete(cl.instances, ev.Instance.Name)
-			for idx, hr := range cl.hashrings {
-				cl.hashrings[idx] = hr.RemoveNode(ev.Instance.Name)
+		st := stagedTransition{src: src, prev: prev, tr: tr}
+		if tr.forget {
+			st.ins = prev
+			staged[src.Name] = nil
+		} else {
+			st.ins = newInstance(prev, src, tr.to, now)
+			switch {
+			case tr.to == InstanceStatusAlive:
+				st.ins.lastSeenHealthy.Store(now.UnixNano())
+			case tr.to == InstanceStatusPending && st.ins.evictAt.IsZero():
+				st.hold = cl.resolveHold(st.ins, now)
+				st.ins.evictAt = now.Add(st.hold)
 			}
-			cl.mu.Unlock()
+			if prev != nil && prev.status == InstanceStatusDead && !prev.wentDownAt.IsZero() &&
+				(tr.to == InstanceStatusAlive || tr.to == InstanceStatusDegraded) {
+				st.ins.recordRecovery(now.Sub(prev.wentDownAt))
+			}
+			staged[src.Name] = st.ins
+		}
+
+		wasInRing := prev != nil && cl.inHashrings(prev.status)
+		inRing := !tr.forget && cl.inHashrings(tr.to)
+		ringChanged = ringChanged || wasInRing != inRing
+
+		transitions = append(transitions, st)
+	}
+
+	if len(transitions) == 0 {
+		return
+	}
+
+	var hashrings []*hashring.HashRing
+	if ringChanged {
+		hashrings = cl.newHashrings(cl.ringNodes(staged))
+	}
 
-		case <-ctx.Done():
-			resErr = errors.Join(resErr, fmt.Errorf("running context: %w", ctx.Err()))
+	cl.mu.Lock()
+	for name, ins := range staged {
+		if ins == nil {
+			delete(cl.instances, name)
+			continue
 		}
+		cl.instances[name] = ins
 	}
+	if ringChanged {
+		cl.hashrings = hashrings
+	}
+	cl.mu.Unlock()
 
-	return resErr
+	for _, st := range transitions {
+		cl.handleTransition(st)
+	}
 }
 
-// Get list of alive instances now.
+// Performs side effects of published transition.
+func (cl *Client) handleTransition(st stagedTransition) {
+	src, prev, ins, tr := st.src, st.prev, st.ins, st.tr
+
+	// Changed address or port is reported, whether status is changed with it or not.
+	// Instance keeps its position in hashrings, as it is identified by name.
+	if prev != nil && !tr.forget && !prev.source().SameEndpoint(src) {
+		cl.eventHandler(Event{Type: EventTypeInstanceUpdated, Instance: ins})
+	}
+
+	if prev != nil && prev.status == tr.to && !tr.forget {
+		// Refresh of instance data, status is not changed.
+		return
+	}
+
+	switch {
+	case tr.forget:
+		cl.eventHandler(Event{Type: EventTypeInstancePurged, Instance: ins})
+
+	case tr.to == InstanceStatusAlive || tr.to == InstanceStatusDegraded:
+		cl.cancelTimers(prev)
+
+		evType := EventTypeInstanceUp
+		if tr.to == InstanceStatusDegraded {
+			evType = EventTypeInstanceDegraded
+		}
+		cl.eventHandler(Event{Type: evType, Instance: ins})
+
+		if ins.name == cl.self && cl.selfLost {
+			cl.selfLost = false
+			cl.eventHandler(Event{Type: EventTypeSelfRecovered, Instance: ins})
+		}
+
+	case tr.to == InstanceStatusPending:
+		if err := cl.pih.AddFor(src, ins.gen, st.hold); err != nil {
+			cl.logger.Error().
+				Err(fmt.Errorf("adding instance to PIH: %w", err)).
+				Send()
+		}
+
+		cl.eventHandler(Event{Type: EventTypeInstanceDown, Instance: ins})
+
+		if ins.name == cl.self {
+			cl.selfLost = true
+			cl.eventHandler(Event{Type: EventTypeSelfLost, Instance: ins})
+		}
+
+	case tr.to == InstanceStatusDead:
+		cl.eventHandler(Event{Type: EventTypeInstanceEvicted, Instance: ins})
+
+		if ins.name == cl.self {
+			cl.eventHandler(Event{Type: EventTypeSelfEvicted, Instance: ins})
+		}
+
+		if cl.tombstoneRetention == 0 {
+			cl.applyInput(src, instanceInputRetentionExpired, ins.gen)
+			return
+		}
+
+		if err := cl.tombstones.Add(src, ins.gen); err != nil {
+			cl.logger.Error().
+				Err(fmt.Errorf("adding instance to tombstones holder: %w", err)).
+				Send()
+		}
+	}
+}
+
+// Get sorted names of instances, that must be present in hashrings,
+// taking staged instances into account.
+func (cl *Client) ringNodes(staged map[string]*Instance) []string {
+	nodes := make([]string, 0, len(cl.instances)+len(staged))
+	for name, ins := range cl.instances {
+		if _, found := staged[name]; !found && cl.inHashrings(ins.status) {
+			nodes = append(nodes, name)
+		}
+	}
+	for name, ins := range staged {
+		if ins != nil && cl.inHashrings(ins.status) {
+			nodes = append(nodes, name)
+		}
+	}
+	slices.Sort(nodes)
+
+	return nodes
+}
+
+// Builds hashrings of given nodes with configured hash funcs.
+func (cl *Client) newHashrings(nodes []string) []*hashring.HashRing {
+	hashrings := make([]*hashring.HashRing, 0, len(cl.hashFuncs))
+	for _, hashFunc := range cl.hashFuncs {
+		// Ring takes ownership of nodes slice.
+		if hashFunc == nil {
+			hashrings = append(hashrings, hashring.New(slices.Clone(nodes)))
+			continue
+		}
+		hashrings = append(hashrings, hashring.NewWithHash(slices.Clone(nodes), hashFunc))
+	}
+
+	return hashrings
+}
+
+// Reports whether instance with given status must be present in hashrings.
+func (cl *Client) inHashrings(status InstanceStatus) bool {
+	switch status {
+	case InstanceStatusAlive, InstanceStatusPending:
+		return true
+	case InstanceStatusDegraded:
+		return cl.degradedInHashrings
+	default:
+		return false
+	}
+}
+
+// Cancels timers, scheduled for previous state of the instance.
+func (cl *Client) cancelTimers(prev *Instance) {
+	if prev == nil {
+		return
+	}
+
+	var (
+		holder *pending_instances_holder.PendingInstancesHolder
+		name   string
+	)
+	switch prev.status {
+	case InstanceStatusPending:
+		holder, name = cl.pih, "PIH"
+	case InstanceStatusDead:
+		holder, name = cl.tombstones, "tombstones holder"
+	default:
+		return
+	}
+
+	if err := holder.Remove(prev.source()); err != nil {
+		cl.logger.Error().
+			Err(fmt.Errorf("removing instance from %s: %w", name, err)).
+			Send()
+	}
+}
+
+// Get channel, that is closed after the first scan of consul is applied.
+// Until then instances list is empty and data holders lookups fail.
+// Channel is renewed, when client stops, so it is not closed until the next run gets ready.
+func (cl *Client) Ready() <-chan struct{} {
+	return cl.getReady()
+}
+
+// Blocks until the first scan of consul is applied or ctx is done.
+func (cl *Client) WaitReady(ctx context.Context) error {
+	select {
+	case <-cl.getReady():
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for client readiness: %w", ctx.Err())
+	}
+}
+
+// Get list of alive, degraded and pending instances now, sorted by name.
+// If filters are given, only instances matching all of them are returned.
 // Client must be started to run this method properly.
-func (cl *Client) GetInstances() ([]*Instance, error) {
+func (cl *Client) GetInstances(filters ...InstanceFilter) ([]*Instance, error) {
+	return cl.getInstances(append(slices.Clip(filters), HasStatus(InstanceStatusAlive, InstanceStatusDegraded, InstanceStatusPending)))
+}
+
+// Get list of instances with any of given statuses now, sorted by name.
+// Dead instances are kept for tombstone retention period (see WithTombstoneRetention).
+// Client must be started to run this method properly.
+func (cl *Client) GetInstancesByStatus(statuses ...InstanceStatus) ([]*Instance, error) {
+	return cl.getInstances([]InstanceFilter{HasStatus(statuses...)})
+}
+
+func (cl *Client) getInstances(filters []InstanceFilter) ([]*Instance, error) {
+	if err := cl.checkStaleness(); err != nil {
+		return nil, err
+	}
+
+	cl.mu.RLock()
+	inses := make([]*Instance, 0, len(cl.instances))
+	for _, ins := range cl.instances {
+		if matchFilters(ins, filters) {
+			inses = append(inses, ins)
+		}
+	}
+	cl.mu.RUnlock()
+
+	slices.SortFunc(inses, func(a, b *Instance) int { return strings.Compare(a.Name(), b.Name()) })
+
+	return inses, nil
+}
+
+// Get instance with given name.
+// Dead instance is returned during tombstone retention period,
+// so it may be distinguished from never seen one.
+// If there is no such instance, ErrInstanceNotFound is returned.
+// Client must be started to run this method properly.
+func (cl *Client) GetInstance(name string) (*Instance, error) {
+	if err := cl.checkStaleness(); err != nil {
+		return nil, err
+	}
+
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	ins, found := cl.instances[name]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrInstanceNotFound, name)
+	}
+
+	return ins, nil
+}
+
+func (cl *Client) getReady() chan struct{} {
 	cl.mu.RLock()
 	defer cl.mu.RUnlock()
 
-	return maps.Values(cl.instances), nil
+	return cl.ready
+}
+
+// Same as GetInstances, but waits for client readiness first.
+func (cl *Client) GetInstancesContext(ctx context.Context, filters ...InstanceFilter) ([]*Instance, error) {
+	if err := cl.WaitReady(ctx); err != nil {
+		return nil, err
+	}
+
+	return cl.GetInstances(filters...)
 }
 
 // Get list of instances that hold given key.
 // Client must be started to run this method properly.
 func (cl *Client) GetDataHolders(key string) ([]*Instance, error) {
-	inses := map[*Instance]struct{}{}
+	return cl.getDataHolders(key)
+}
+
+// Same as GetDataHolders, but waits for client readiness first.
+func (cl *Client) GetDataHoldersContext(ctx context.Context, key string) ([]*Instance, error) {
+	if err := cl.WaitReady(ctx); err != nil {
+		return nil, err
+	}
+
+	return cl.GetDataHolders(key)
+}
+
+// Get instances that hold given keys, grouped by instance.
+// Key is present in group of every instance that holds it.
+// All keys are resolved against the same topology state.
+// Client must be started to run this method properly.
+func (cl *Client) GetDataHoldersBatch(keys []string) (map[*Instance][]string, error) {
+	return GetDataHoldersBatchOf(cl, keys)
+}
+
+func (cl *Client) getDataHolders(strKey string) ([]*Instance, error) {
+	if err := cl.checkStaleness(); err != nil {
+		return nil, err
+	}
 
 	cl.mu.RLock()
 	defer cl.mu.RUnlock()
 
+	holders := make([]*Instance, 0, len(cl.hashrings))
 	for _, hr := range cl.hashrings {
-		node, ok := hr.GetNode(key)
-		if !ok {
-			return nil, fmt.Errorf("data holder for key %s not found", key)
+		ins, err := cl.getRingHolder(hr, strKey)
+		if err != nil {
+			return nil, err
 		}
 
-		ins, found := cl.instances[node]
-		if !found {
-			return nil, fmt.Errorf("unknow instance node: %s", node)
+		if !slices.Contains(holders, ins) {
+			holders = append(holders, ins)
 		}
+	}
+
+	slices.SortFunc(holders, func(a, b *Instance) int { return strings.Compare(a.Name(), b.Name()) })
+
+	return holders, nil
+}
 
-		inses[ins] = struct{}{}
+func getDataHoldersBatch[K any](
+	cl *Client,
+	keys []K,
+	appendKey func(dst []byte, key K) []byte,
+) (map[*Instance][]K, error) {
+	if err := cl.checkStaleness(); err != nil {
+		return nil, err
 	}
 
-	keys := maps.Keys(inses)
-	sort.Slice(keys, func(i, j int) bool { return keys[i].Name() < keys[j].Name() })
+	buf := make([]byte, 0, keyBufSize)
+
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	groupCap := 1
+	if len(cl.instances) > 0 {
+		groupCap = len(keys)/len(cl.instances) + 1
+	}
+
+	plan := make(map[*Instance][]K, len(cl.instances))
+	holders := make([]*Instance, 0, len(cl.hashrings))
+
+	for _, key := range keys {
+		buf = appendKey(buf[:0], key)
+		strKey := bytesToString(buf)
+
+		holders = holders[:0]
+		for _, hr := range cl.hashrings {
+			ins, err := cl.getRingHolder(hr, strKey)
+			if err != nil {
+				return nil, err
+			}
 
-	return keys, nil
+			if !slices.Contains(holders, ins) {
+				holders = append(holders, ins)
+			}
+		}
+
+		for _, ins := range holders {
+			group, found := plan[ins]
+			if !found {
+				group = make([]K, 0, groupCap)
+			}
+			plan[ins] = append(group, key)
+		}
+	}
+
+	return plan, nil
+}
+
+// Reports whether local instance (see WithSelf) holds given key in any of hashrings.
+// Client must be started to run this method properly.
+func (cl *Client) IsLocal(key string) (bool, error) {
+	idx, err := cl.LocalReplicaIndex(key)
+	if err != nil {
+		return false, err
+	}
+
+	return idx >= 0, nil
+}
+
+// Get index of the first hashring, in which local instance (see WithSelf) holds given key.
+// 0 means that local instance is the primary holder, greater values mean backup replicas.
+// -1 is returned if local instance does not hold the key.
+// Client must be started to run this method properly.
+func (cl *Client) LocalReplicaIndex(key string) (int, error) {
+	if cl.self == "" {
+		return -1, errSelfNotSet
+	}
+
+	if err := cl.checkStaleness(); err != nil {
+		return -1, err
+	}
+
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	for idx, hr := range cl.hashrings {
+		ins, err := cl.getRingHolder(hr, key)
+		if err != nil {
+			return -1, err
+		}
+
+		if ins.name == cl.self {
+			return idx, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// cl.mu must be held by caller.
+func (cl *Client) getRingHolder(hr *hashring.HashRing, key string) (*Instance, error) {
+	node, ok := hr.GetNode(key)
+	if !ok {
+		// Key may refer to buffer on the stack of the caller, so it is cloned to not make it escape.
+		return nil, fmt.Errorf("data holder for key %s not found", strings.Clone(key))
+	}
+
+	ins, found := cl.instances[node]
+	if !found || !cl.inHashrings(ins.status) {
+		return nil, fmt.Errorf("unknow instance node: %s", node)
+	}
+
+	return ins, nil
 }
 
 // Registers new instance of cl.appName with given parameters.
+// Call is retried according to WithRetryPolicy without deadline,
+// use RegisterContext to bound it.
 func (cl *Client) Register(hostname string, address string) error {
-	if _, err := cl.cl.Catalog().Register(&consul.CatalogRegistration{
+	return cl.RegisterContext(context.Background(), hostname, address)
+}
+
+// Same as Register, but retries of the call are bounded by ctx.
+func (cl *Client) RegisterContext(ctx context.Context, hostname string, address string) error {
+	reg := &consul.CatalogRegistration{
 		ID:      uuid.NewString(),
 		Node:    hostname,
 		Address: address,
@@ -202,7 +942,13 @@ func (cl *Client) Register(hostname string, address string) error {
 				Status:  consul.HealthPassing,
 			},
 		},
-	}, nil); err != nil {
+	}
+
+	err := cl.retrier.Do(ctx, "catalog register", func() error {
+		_, err := cl.cl.Catalog().Register(reg, (&consul.WriteOptions{}).WithContext(ctx))
+		return err
+	})
+	if err != nil {
 		return fmt.Errorf("registering in consul: %w", err)
 	}
 
@@ -210,11 +956,23 @@ func (cl *Client) Register(hostname string, address string) error {
 }
 
 // Deregisters instance of cl.appName with given parameters.
+// Call is retried according to WithRetryPolicy without deadline,
+// use DeregisterContext to bound it.
 func (cl *Client) Deregister(hostname string) error {
-	_, err := cl.cl.Catalog().Deregister(&consul.CatalogDeregistration{
+	return cl.DeregisterContext(context.Background(), hostname)
+}
+
+// Same as Deregister, but retries of the call are bounded by ctx.
+func (cl *Client) DeregisterContext(ctx context.Context, hostname string) error {
+	dereg := &consul.CatalogDeregistration{
 		Node:      hostname,
 		ServiceID: cl.appName + "_" + hostname,
-	}, nil)
+	}
+
+	err := cl.retrier.Do(ctx, "catalog deregister", func() error {
+		_, err := cl.cl.Catalog().Deregister(dereg, (&consul.WriteOptions{}).WithContext(ctx))
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("deregistering from consul: %w", err)
 	}
@@ -0,0 +1,100 @@
+//go:build integration
+
+package go_consul_instance_manager_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/suite"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Runs the client suite against real consul in docker,
+// so behavior of the fake is checked against the real one.
+// Run with: go test -tags integration -run TestImanIntegrationSuite
+func TestImanIntegrationSuite(t *testing.T) {
+	suite.Run(t, &ImanTestSuite{
+		newConsul: newContainerConsul,
+	})
+}
+
+// Consul dev agent, running in docker container.
+type containerConsul struct {
+	container testcontainers.Container
+	addr      string
+}
+
+func newContainerConsul() (consulServer, error) {
+	ctx := context.TODO()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "hashicorp/consul:latest",
+			ExposedPorts: []string{"8500/tcp"},
+			WaitingFor: wait.ForHTTP("/v1/status/leader").
+				WithPort("8500/tcp").
+				WithStatusCodeMatcher(func(status int) bool { return status == http.StatusOK }).
+				WithResponseMatcher(func(body io.Reader) bool {
+					// Empty leader is reported as "" until the agent is elected.
+					raw, err := io.ReadAll(body)
+					return err == nil && len(raw) > len(`""`)
+				}).
+				WithStartupTimeout(time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("starting consul container: %w", err)
+	}
+
+	addr, err := container.PortEndpoint(ctx, "8500/tcp", "")
+	if err != nil {
+		_ = container.Terminate(ctx)
+		return nil, fmt.Errorf("getting consul endpoint: %w", err)
+	}
+
+	return &containerConsul{
+		container: container,
+		addr:      addr,
+	}, nil
+}
+
+func (c *containerConsul) Client() (*consul.Client, error) {
+	cfg := consul.DefaultConfig()
+	cfg.Address = c.addr
+
+	cl, err := consul.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating consul client: %w", err)
+	}
+
+	return cl, nil
+}
+
+// Destroys all sessions, locks held by them are released.
+func (c *containerConsul) InvalidateSessions() {
+	cl, err := c.Client()
+	if err != nil {
+		return
+	}
+
+	sessions, _, err := cl.Session().List(nil)
+	if err != nil {
+		return
+	}
+
+	for _, sess := range sessions {
+		_, _ = cl.Session().Destroy(sess.ID, nil)
+	}
+}
+
+func (c *containerConsul) Close() {
+	_ = c.container.Terminate(context.TODO())
+}
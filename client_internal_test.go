@@ -0,0 +1,144 @@
+package go_consul_instance_manager
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/serialx/hashring"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalReplicaIndex(t *testing.T) {
+	cl := newTestClient("host1", "host2", "host3")
+	cl.hashrings = append(cl.hashrings, hashring.NewWithHash(
+		[]string{"host1", "host2", "host3"},
+		func(b []byte) hashring.HashKey { return hashring.Uint32HashKey(len(b)) },
+	))
+
+	_, err := cl.IsLocal("abc")
+	require.Error(t, err)
+
+	for _, self := range []string{"host1", "host2", "host3"} {
+		cl.self = self
+
+		for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+			idx, err := cl.LocalReplicaIndex(key)
+			require.NoError(t, err)
+
+			isLocal, err := cl.IsLocal(key)
+			require.NoError(t, err)
+			require.Equal(t, idx >= 0, isLocal)
+
+			primary, _ := cl.hashrings[0].GetNode(key)
+			backup, _ := cl.hashrings[1].GetNode(key)
+			switch self {
+			case primary:
+				require.Equal(t, 0, idx)
+			case backup:
+				require.Equal(t, 1, idx)
+			default:
+				require.Equal(t, -1, idx)
+			}
+		}
+	}
+}
+
+func TestLeasePartition(t *testing.T) {
+	cl := newTestClient()
+	cl.leasePartitions = 16
+
+	seen := map[int]struct{}{}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+
+		partition := cl.LeasePartition(key)
+		require.GreaterOrEqual(t, partition, 0)
+		require.Less(t, partition, 16)
+		require.Equal(t, partition, cl.LeasePartition(key))
+
+		seen[partition] = struct{}{}
+	}
+	require.Len(t, seen, 16)
+}
+
+func TestGetInstances_Filters(t *testing.T) {
+	cl := newTestClient()
+	cl.instances = map[string]*Instance{
+		"host3": {name: "host3", status: InstanceStatusPending, tags: []string{"db"}},
+		"host1": {name: "host1", status: InstanceStatusAlive, tags: []string{"db", "api"}},
+		"host2": {name: "host2", status: InstanceStatusAlive, meta: map[string]string{"zone": "a"}},
+		"host0": {name: "host0", status: InstanceStatusDead, tags: []string{"db"}},
+	}
+
+	names := func(inses []*Instance) []string {
+		res := make([]string, 0, len(inses))
+		for _, ins := range inses {
+			res = append(res, ins.Name())
+		}
+		return res
+	}
+
+	inses, err := cl.GetInstances()
+	require.NoError(t, err)
+	require.Equal(t, []string{"host1", "host2", "host3"}, names(inses))
+
+	inses, err = cl.GetInstances(HasTag("db"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"host1", "host3"}, names(inses))
+
+	inses, err = cl.GetInstances(HasTag("db"), HasStatus(InstanceStatusAlive))
+	require.NoError(t, err)
+	require.Equal(t, []string{"host1"}, names(inses))
+
+	inses, err = cl.GetInstances(HasMeta("zone", "a"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"host2"}, names(inses))
+
+	inses, err = cl.GetInstancesByStatus(InstanceStatusPending)
+	require.NoError(t, err)
+	require.Equal(t, []string{"host3"}, names(inses))
+
+	ins, err := cl.GetInstance("host2")
+	require.NoError(t, err)
+	require.Equal(t, "host2", ins.Name())
+
+	inses, err = cl.GetInstancesByStatus(InstanceStatusDead)
+	require.NoError(t, err)
+	require.Equal(t, []string{"host0"}, names(inses))
+
+	ins, err = cl.GetInstance("host0")
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusDead, ins.Status())
+
+	_, err = cl.GetInstance("host4")
+	require.ErrorIs(t, err, ErrInstanceNotFound)
+}
+
+func TestResolveHold(t *testing.T) {
+	now := time.Now()
+	cl := newTestClient()
+	cl.holdDur = time.Second * 10
+	cl.holdResolver = DownHoldFromMeta("hold")
+
+	stateless := &Instance{name: "stateless"}
+	stateful := &Instance{name: "stateful", meta: map[string]string{"hold": "1m"}}
+	invalid := &Instance{name: "invalid", meta: map[string]string{"hold": "long"}}
+
+	require.Equal(t, time.Second*10, cl.resolveHold(stateless, now))
+	require.Equal(t, time.Minute, cl.resolveHold(stateful, now))
+	require.Equal(t, time.Second*10, cl.resolveHold(invalid, now))
+
+	// Adaptation: downs above are still recent, so they are treated as rolling deployment.
+	cl.maxAdaptiveHold = time.Second * 35
+	require.Equal(t, time.Second*35, cl.resolveHold(stateless, now.Add(time.Second)))
+
+	// Downs are pruned after default hold, but instance used to return after eviction.
+	stateless.recordRecovery(time.Second * 10)
+	stateless.recordRecovery(time.Second * 14)
+	require.Equal(t, time.Second*12, stateless.MeanRecovery())
+	require.Equal(t, time.Second*24, cl.resolveHold(stateless, now.Add(time.Minute)))
+
+	// Resolved hold, exceeding max, is not shortened.
+	require.Equal(t, time.Minute, cl.resolveHold(stateful, now.Add(time.Minute)))
+}
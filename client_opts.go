@@ -6,6 +6,8 @@ import (
 	"time"
 
 	consul "github.com/hashicorp/consul/api"
+	"github.com/horockey/go-consul-instance-manager/clock"
+	"github.com/horockey/go-consul-instance-manager/internal/healthchecker"
 	"github.com/horockey/go-toolbox/options"
 	"github.com/rs/zerolog"
 	"github.com/serialx/hashring"
@@ -32,6 +34,18 @@ func WithConsulClient(cc *consul.Client) options.Option[Client] {
 	}
 }
 
+// Sets clock, used for timers, polling and timestamps.
+// Default is real time clock. Fake clock of clocktest package makes tests deterministic.
+func WithClock(clk clock.Clock) options.Option[Client] {
+	return func(target *Client) error {
+		if clk == nil {
+			return errors.New("got nil clock")
+		}
+		target.clock = clk
+		return nil
+	}
+}
+
 // Sets duration, for which node will be marked as pending before being removed from instances list.
 // Default is 15s.
 func WithDownHoldDuration(dur time.Duration) options.Option[Client] {
@@ -45,6 +59,180 @@ func WithDownHoldDuration(dur time.Duration) options.Option[Client] {
 	}
 }
 
+// Sets resolver of per instance hold duration, e.g. DownHoldFromMeta.
+// Resolver is called from the client event loop, so it must not block.
+// Non-positive resolved duration falls back to the one, set by WithDownHoldDuration.
+func WithDownHoldResolver(r func(ins *Instance) time.Duration) options.Option[Client] {
+	return func(target *Client) error {
+		if r == nil {
+			return errors.New("got nil hold resolver")
+		}
+
+		target.holdResolver = r
+		return nil
+	}
+}
+
+// Enables adaptive lengthening of hold duration up to given max.
+// Hold is lengthened, when other instances went down recently (e.g. during rolling deployment),
+// and for instances, that used to return after being evicted as dead, so they are held long enough to return.
+// Hold is never shortened by adaptation.
+// Disabled by default.
+func WithAdaptiveDownHold(maxHold time.Duration) options.Option[Client] {
+	return func(target *Client) error {
+		if maxHold <= 0 {
+			return fmt.Errorf("duration must be positive, got: %d", maxHold)
+		}
+
+		target.maxAdaptiveHold = maxHold
+		return nil
+	}
+}
+
+// Sets duration, for which dead node is kept in instances list with dead status.
+// Zero duration makes client to forget dead nodes immediately.
+// Default is 5m.
+func WithTombstoneRetention(dur time.Duration) options.Option[Client] {
+	return func(target *Client) error {
+		if dur < 0 {
+			return fmt.Errorf("duration must not be negative, got: %d", dur)
+		}
+
+		target.tombstoneRetention = dur
+		return nil
+	}
+}
+
+// Sets whether degraded instances (with warning health status) are kept in hashrings.
+// If not, keys of degraded instance are moved to other instances until it becomes alive again.
+// Default is true.
+func WithDegradedInHashrings(keep bool) options.Option[Client] {
+	return func(target *Client) error {
+		target.degradedInHashrings = keep
+		return nil
+	}
+}
+
+// Sets hysteresis of instance health transitions.
+// Instance is reported down only after given count of consecutive failing observations
+// and is reported up again only after given count of consecutive passing ones.
+// Default is 1 for both.
+func WithHealthHysteresis(failures uint, passes uint) options.Option[Client] {
+	return func(target *Client) error {
+		if failures == 0 || passes == 0 {
+			return fmt.Errorf("observations count must be positive, got: %d failures, %d passes", failures, passes)
+		}
+
+		target.damping.Failures = failures
+		target.damping.Passes = passes
+		return nil
+	}
+}
+
+// Enables flap damping of instance health transitions.
+// Every flap adds penalty of 1 to the instance, penalty decays exponentially with given half-life.
+// While penalty is above suppress threshold, instance is considered down,
+// until its penalty decays below reuse threshold.
+// Default is disabled.
+func WithFlapDamping(halfLife time.Duration, suppress float64, reuse float64) options.Option[Client] {
+	return func(target *Client) error {
+		if halfLife <= 0 {
+			return fmt.Errorf("half-life must be positive, got: %d", halfLife)
+		}
+
+		if reuse <= 0 || reuse >= suppress {
+			return fmt.Errorf("reuse threshold must be in (0, suppress threshold), got: %f, %f", reuse, suppress)
+		}
+
+		target.damping.HalfLife = halfLife
+		target.damping.SuppressPenalty = suppress
+		target.damping.ReusePenalty = reuse
+		return nil
+	}
+}
+
+// Enables panic mode, protecting topology from consul outages.
+// Panic is started, when consul responds with error or without known leader,
+// or when more than given fraction of alive instances disappear in one scan.
+// In panic mode no instances are marked pending or evicted,
+// it is ended, when consul responds properly again.
+// Mass disappearance is accepted as real, if consul stays healthy for confirm duration.
+// Default is disabled.
+func WithPanicMode(threshold float64, confirm time.Duration) options.Option[Client] {
+	return func(target *Client) error {
+		if threshold <= 0 || threshold >= 1 {
+			return fmt.Errorf("threshold must be in (0, 1), got: %f", threshold)
+		}
+
+		if confirm < 0 {
+			return fmt.Errorf("duration must not be negative, got: %d", confirm)
+		}
+
+		target.panicCfg = healthchecker.PanicConfig{
+			Enabled:   true,
+			Threshold: threshold,
+			Confirm:   confirm,
+		}
+		return nil
+	}
+}
+
+// Sets max age of topology, after which lookups fail with ErrStaleTopology.
+// Age is counted from the last scan of consul, applied to topology (see SyncStatus).
+// Default is 0, that means lookups never fail because of staleness.
+func WithMaxStaleness(dur time.Duration) options.Option[Client] {
+	return func(target *Client) error {
+		if dur <= 0 {
+			return fmt.Errorf("duration must be positive, got: %d", dur)
+		}
+
+		target.maxStaleness = dur
+		return nil
+	}
+}
+
+// Sets retry policy of consul calls, made by client.
+// Failed call is retried up to maxAttempts in total with exponential backoff and jitter,
+// starting from initialBackoff and limited by maxBackoff.
+// Default is a single attempt.
+func WithRetryPolicy(maxAttempts uint, initialBackoff time.Duration, maxBackoff time.Duration) options.Option[Client] {
+	return func(target *Client) error {
+		if maxAttempts == 0 {
+			return errors.New("max attempts must be positive")
+		}
+
+		if initialBackoff <= 0 || maxBackoff < initialBackoff {
+			return fmt.Errorf("backoffs must be positive and ordered, got: %d, %d", initialBackoff, maxBackoff)
+		}
+
+		target.retryPolicy.MaxAttempts = maxAttempts
+		target.retryPolicy.InitialBackoff = initialBackoff
+		target.retryPolicy.MaxBackoff = maxBackoff
+		return nil
+	}
+}
+
+// Enables circuit breaker of consul calls, made by client.
+// Breaker is opened after given count of consecutive failed attempts,
+// while it is open, calls fail immediately with ErrCircuitOpen.
+// After cooldown single trial call is allowed, its success closes the breaker.
+// Default is disabled.
+func WithCircuitBreaker(failures uint, cooldown time.Duration) options.Option[Client] {
+	return func(target *Client) error {
+		if failures == 0 {
+			return errors.New("failures count must be positive")
+		}
+
+		if cooldown <= 0 {
+			return fmt.Errorf("duration must be positive, got: %d", cooldown)
+		}
+
+		target.retryPolicy.BreakerThreshold = failures
+		target.retryPolicy.BreakerCooldown = cooldown
+		return nil
+	}
+}
+
 // Sets interval to check instances list.
 // Default is 1s.
 func WithPollInterval(dur time.Duration) options.Option[Client] {
@@ -58,13 +246,109 @@ func WithPollInterval(dur time.Duration) options.Option[Client] {
 	}
 }
 
+// Sets name of the local instance.
+// It is required for IsLocal and LocalReplicaIndex methods
+// and for self-related events.
+func WithSelf(name string) options.Option[Client] {
+	return func(target *Client) error {
+		if name == "" {
+			return errors.New("got empty self name")
+		}
+
+		target.self = name
+		return nil
+	}
+}
+
+// Sets handler for client events.
+// Handler is called synchronously from Start, so it should not block.
+// Default is no-op.
+func WithEventHandler(h func(Event)) options.Option[Client] {
+	return func(target *Client) error {
+		if h == nil {
+			return errors.New("got nil event handler")
+		}
+
+		target.eventHandler = h
+		return nil
+	}
+}
+
+// Enables leader election among instances of the app.
+// Election is based on consul session and KV lock, so WithSelf is required.
+// Handlers are called synchronously from Start, so they should not block.
+func WithLeaderElection(onGained func(), onLost func()) options.Option[Client] {
+	return func(target *Client) error {
+		if onGained == nil || onLost == nil {
+			return errors.New("got nil leadership handler")
+		}
+
+		target.leaderElection = true
+		target.onLeaderGained = onGained
+		target.onLeaderLost = onLost
+		return nil
+	}
+}
+
+// Sets TTL of consul sessions, created by client.
+// Consul requires TTL to be between 10s and 24h.
+// Default is 15s.
+func WithSessionTTL(dur time.Duration) options.Option[Client] {
+	return func(target *Client) error {
+		if dur < time.Second*10 || dur > time.Hour*24 {
+			return fmt.Errorf("duration must be between 10s and 24h, got: %s", dur)
+		}
+
+		target.sessionTTL = dur
+		return nil
+	}
+}
+
+// Sets lock-delay of distributed locks.
+// After lock holder's session is invalidated, lock can not be acquired during this period.
+// Consul allows lock-delay up to 60s.
+// Default is 15s.
+func WithLockDelay(dur time.Duration) options.Option[Client] {
+	return func(target *Client) error {
+		if dur <= 0 || dur > time.Minute {
+			return fmt.Errorf("duration must be between 0 and 60s, got: %s", dur)
+		}
+
+		target.lockDelay = dur
+		return nil
+	}
+}
+
+// Sets count of hash ranges, for which ownership leases are issued.
+// Must be the same for all instances of the app.
+// Default is 256.
+func WithLeasePartitions(n uint) options.Option[Client] {
+	return func(target *Client) error {
+		if n == 0 {
+			return errors.New("lease partitions count must be positive")
+		}
+
+		target.leasePartitions = n
+		return nil
+	}
+}
+
+// Makes Close to deregister local instance from consul.
+// WithSelf is required.
+func WithDeregisterOnClose() options.Option[Client] {
+	return func(target *Client) error {
+		target.deregisterOnClose = true
+		return nil
+	}
+}
+
 func WithBackupHashring(hashFunc hashring.HashFunc) options.Option[Client] {
 	return func(target *Client) error {
 		if hashFunc == nil {
 			return errors.New("got nil backup hashfunc")
 		}
 
-		target.hashrings = append(target.hashrings, hashring.NewWithHash([]string{}, hashFunc))
+		target.hashFuncs = append(target.hashFuncs, hashFunc)
 		return nil
 	}
 }
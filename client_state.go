@@ -0,0 +1,6 @@
+package go_consul_instance_manager
+
+//go:generate go-enum
+
+// ENUM(new, starting, running, stopped)
+type ClientState uint8
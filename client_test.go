@@ -2,17 +2,20 @@ package go_consul_instance_manager_test
 
 import (
 	"context"
+	"errors"
+	"strconv"
 	"testing"
 	"time"
 
-	"github.com/hashicorp/consul/api"
+	consul "github.com/hashicorp/consul/api"
 	"github.com/serialx/hashring"
 
 	consul_iman "github.com/horockey/go-consul-instance-manager"
+	"github.com/horockey/go-consul-instance-manager/clock/clocktest"
+	"github.com/horockey/go-consul-instance-manager/consultest"
 
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
-	"github.com/testcontainers/testcontainers-go"
 )
 
 const (
@@ -21,59 +24,128 @@ const (
 	hostName2   = "host2"
 	addr1       = "http://host1:8080"
 	addr2       = "http://host2:8080"
-	consulAddr  = "localhost:8500"
+
+	pollInterval = time.Millisecond * 500
+	// Long enough for pending status to be seen before eviction, while clock is advanced by pollUntil.
+	downHold = time.Second * 5
 )
 
+// Consul, the suite is run against.
+type consulServer interface {
+	Client() (*consul.Client, error)
+	// Invalidates all sessions, as if consul lost them.
+	InvalidateSessions()
+	Close()
+}
+
 type ImanTestSuite struct {
 	suite.Suite
 
+	newConsul func() (consulServer, error)
+
 	iman   *consul_iman.Client
-	consul testcontainers.Container
+	consul consulServer
+	clock  *clocktest.Clock
 }
 
 func (s *ImanTestSuite) SetupTest() {
 	t := s.T()
-	ctx := context.TODO()
 
-	consulCfg := api.DefaultConfig()
-	consulCfg.Address = consulAddr
-	consulClient, err := api.NewClient(consulCfg)
+	var err error
+	s.consul, err = s.newConsul()
 	require.NoError(t, err)
 
+	consulClient, err := s.consul.Client()
+	require.NoError(t, err)
+
+	s.clock = clocktest.New(time.Now())
 	s.iman, err = consul_iman.NewClient(
 		serviceName,
-		consul_iman.WithDownHoldDuration(time.Second),
-		consul_iman.WithPollInterval(time.Millisecond*500),
+		consul_iman.WithDownHoldDuration(downHold),
+		consul_iman.WithPollInterval(pollInterval),
 		consul_iman.WithConsulClient(consulClient),
+		consul_iman.WithClock(s.clock),
 	)
 	require.NoError(t, err)
-
-	s.consul, err = setupConsul()
-	require.NoError(t, err)
-
-	err = s.consul.Start(ctx)
-	require.NoError(t, err)
-	time.Sleep(time.Second)
 }
 
 func (s *ImanTestSuite) TearDownTest() {
-	err := s.consul.Terminate(context.TODO())
-	require.NoError(s.T(), err)
+	if s.consul != nil {
+		s.consul.Close()
+		s.consul = nil
+	}
 }
 
 func TestImanTestSuite(t *testing.T) {
-	suite.Run(t, &ImanTestSuite{})
+	suite.Run(t, &ImanTestSuite{
+		newConsul: func() (consulServer, error) {
+			return consultest.NewServer()
+		},
+	})
+}
+
+// Gets fake consul of the suite for tests, that simulate its failures.
+// Test is skipped, when suite is run against real consul.
+func (s *ImanTestSuite) fakeConsul() *consultest.Server {
+	srv, ok := s.consul.(*consultest.Server)
+	if !ok {
+		s.T().Skip("fake consul is required")
+	}
+
+	return srv
+}
+
+// Advances clock by poll interval until cond is met,
+// so running clients rescan consul and fire their due timers.
+func (s *ImanTestSuite) pollUntil(cond func() bool) {
+	s.T().Helper()
+
+	require.Eventually(s.T(), func() bool {
+		if cond() {
+			return true
+		}
+		s.clock.Advance(pollInterval)
+		return false
+	}, time.Second*5, time.Millisecond*10)
+}
+
+// Advances clock by poll interval until event is received.
+func (s *ImanTestSuite) pollEvent(events <-chan consul_iman.Event) consul_iman.Event {
+	s.T().Helper()
+
+	var ev consul_iman.Event
+	s.pollUntil(func() bool {
+		select {
+		case ev = <-events:
+			return true
+		default:
+			return false
+		}
+	})
+
+	return ev
+}
+
+// Reports whether instance with given name is known by iman and has given status.
+func hasStatus(iman *consul_iman.Client, name string, status consul_iman.InstanceStatus) func() bool {
+	return func() bool {
+		ins, err := iman.GetInstance(name)
+		return err == nil && ins.Status() == status
+	}
 }
 
 func (s *ImanTestSuite) TestIman_Normal() {
-	ctx := context.TODO()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*5)
+	defer cancel()
 	t := s.T()
 
+	err := s.iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
 	go s.iman.Start(ctx)
 
-	err := s.iman.Register(hostName1, addr1)
+	err = s.iman.WaitReady(ctx)
 	require.NoError(t, err)
-	time.Sleep(time.Millisecond * 600)
 
 	inses, err := s.iman.GetInstances()
 	require.NoError(t, err)
@@ -82,15 +154,175 @@ func (s *ImanTestSuite) TestIman_Normal() {
 	require.Equal(t, consul_iman.InstanceStatusAlive, inses[0].Status())
 }
 
-func (s *ImanTestSuite) TestIman_InstanceDown_AndRecover() {
-	ctx := context.TODO()
+func (s *ImanTestSuite) TestIman_Ready() {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*5)
+	defer cancel()
 	t := s.T()
 
+	err := s.iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
 	go s.iman.Start(ctx)
 
+	err = s.iman.WaitReady(ctx)
+	require.NoError(t, err)
+
+	inses, err := s.iman.GetInstances()
+	require.NoError(t, err)
+	require.Len(t, inses, 1)
+
+	holders, err := s.iman.GetDataHoldersContext(ctx, "abc")
+	require.NoError(t, err)
+	require.Len(t, holders, 1)
+	require.Equal(t, hostName1, holders[0].Name())
+}
+
+func (s *ImanTestSuite) TestIman_CloseAndRestart() {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*5)
+	defer cancel()
+	t := s.T()
+
+	require.Equal(t, consul_iman.ClientStateNew, s.iman.State())
+
 	err := s.iman.Register(hostName1, addr1)
 	require.NoError(t, err)
-	time.Sleep(time.Millisecond * 600)
+
+	for i := 0; i < 2; i++ {
+		startErrs := make(chan error, 1)
+		go func() { startErrs <- s.iman.Start(ctx) }()
+
+		err = s.iman.WaitReady(ctx)
+		require.NoError(t, err)
+		require.Equal(t, consul_iman.ClientStateRunning, s.iman.State())
+
+		inses, err := s.iman.GetInstances()
+		require.NoError(t, err)
+		require.Len(t, inses, 1)
+
+		err = s.iman.Close(ctx)
+		require.NoError(t, err)
+		require.NoError(t, <-startErrs)
+		require.Equal(t, consul_iman.ClientStateStopped, s.iman.State())
+	}
+}
+
+func (s *ImanTestSuite) TestIman_CloseBeforeStart() {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*5)
+	defer cancel()
+	t := s.T()
+
+	require.NoError(t, s.iman.Close(ctx))
+	require.Equal(t, consul_iman.ClientStateStopped, s.iman.State())
+	require.NoError(t, s.iman.Close(ctx))
+
+	// Start, that lost the race with Close, must not block.
+	require.NoError(t, s.iman.Start(ctx))
+	require.NoError(t, ctx.Err())
+	require.Equal(t, consul_iman.ClientStateStopped, s.iman.State())
+}
+
+func (s *ImanTestSuite) TestIman_RestartResetsBreaker() {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	defer cancel()
+	t := s.T()
+	fakeConsul := s.fakeConsul()
+
+	consulClient, err := s.consul.Client()
+	require.NoError(t, err)
+
+	iman, err := consul_iman.NewClient(
+		serviceName,
+		consul_iman.WithPollInterval(pollInterval),
+		consul_iman.WithRetryPolicy(1, time.Millisecond, time.Millisecond),
+		consul_iman.WithCircuitBreaker(1, time.Hour),
+		consul_iman.WithConsulClient(consulClient),
+		consul_iman.WithClock(s.clock),
+	)
+	require.NoError(t, err)
+
+	err = iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
+	startErrs := make(chan error, 1)
+	go func() { startErrs <- iman.Start(ctx) }()
+	require.NoError(t, iman.WaitReady(ctx))
+
+	fakeConsul.SetOutage(true)
+	s.pollUntil(func() bool {
+		return iman.ConsulCallStats().BreakerOpen
+	})
+
+	require.NoError(t, iman.Close(ctx))
+	require.NoError(t, <-startErrs)
+	fakeConsul.SetOutage(false)
+
+	// Breaker of the previous run would reject calls for an hour.
+	go func() { startErrs <- iman.Start(ctx) }()
+	require.NoError(t, iman.WaitReady(ctx))
+	require.False(t, iman.ConsulCallStats().BreakerOpen)
+
+	require.NoError(t, iman.Close(ctx))
+	require.NoError(t, <-startErrs)
+}
+
+func (s *ImanTestSuite) TestIman_Staleness() {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	defer cancel()
+	t := s.T()
+	fakeConsul := s.fakeConsul()
+
+	consulClient, err := s.consul.Client()
+	require.NoError(t, err)
+
+	iman, err := consul_iman.NewClient(
+		serviceName,
+		consul_iman.WithPollInterval(pollInterval),
+		consul_iman.WithMaxStaleness(time.Second),
+		consul_iman.WithConsulClient(consulClient),
+		consul_iman.WithClock(s.clock),
+	)
+	require.NoError(t, err)
+	require.Zero(t, iman.SyncStatus())
+
+	err = iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
+	go iman.Start(ctx)
+
+	err = iman.WaitReady(ctx)
+	require.NoError(t, err)
+
+	st := iman.SyncStatus()
+	require.False(t, st.LastSync.IsZero())
+	require.NotZero(t, st.Index)
+	require.Zero(t, st.ConsecutiveFailures)
+
+	fakeConsul.SetOutage(true)
+	s.pollUntil(func() bool {
+		_, err := iman.GetInstances()
+		return errors.Is(err, consul_iman.ErrStaleTopology) && iman.SyncStatus().ConsecutiveFailures > 0
+	})
+
+	_, err = iman.GetDataHolders("abc")
+	require.ErrorIs(t, err, consul_iman.ErrStaleTopology)
+
+	st = iman.SyncStatus()
+	require.Error(t, st.LastError)
+	require.NotZero(t, st.ConsecutiveFailures)
+}
+
+func (s *ImanTestSuite) TestIman_InstanceDown_AndRecover() {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	defer cancel()
+	t := s.T()
+
+	err := s.iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
+	go s.iman.Start(ctx)
+
+	err = s.iman.WaitReady(ctx)
+	require.NoError(t, err)
 
 	inses, err := s.iman.GetInstances()
 	require.NoError(t, err)
@@ -100,7 +332,7 @@ func (s *ImanTestSuite) TestIman_InstanceDown_AndRecover() {
 
 	err = s.iman.Deregister(hostName1)
 	require.NoError(t, err)
-	time.Sleep(time.Millisecond * 600)
+	s.pollUntil(hasStatus(s.iman, hostName1, consul_iman.InstanceStatusPending))
 
 	inses, err = s.iman.GetInstances()
 	require.NoError(t, err)
@@ -110,7 +342,7 @@ func (s *ImanTestSuite) TestIman_InstanceDown_AndRecover() {
 
 	err = s.iman.Register(hostName1, addr1)
 	require.NoError(t, err)
-	time.Sleep(time.Millisecond * 600)
+	s.pollUntil(hasStatus(s.iman, hostName1, consul_iman.InstanceStatusAlive))
 
 	inses, err = s.iman.GetInstances()
 	require.NoError(t, err)
@@ -120,14 +352,17 @@ func (s *ImanTestSuite) TestIman_InstanceDown_AndRecover() {
 }
 
 func (s *ImanTestSuite) TestIman_InstanceDown_Finally() {
-	ctx := context.TODO()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	defer cancel()
 	t := s.T()
 
+	err := s.iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
 	go s.iman.Start(ctx)
 
-	err := s.iman.Register(hostName1, addr1)
+	err = s.iman.WaitReady(ctx)
 	require.NoError(t, err)
-	time.Sleep(time.Millisecond * 600)
 
 	inses, err := s.iman.GetInstances()
 	require.NoError(t, err)
@@ -137,40 +372,315 @@ func (s *ImanTestSuite) TestIman_InstanceDown_Finally() {
 
 	err = s.iman.Deregister(hostName1)
 	require.NoError(t, err)
-	time.Sleep(time.Second * 2)
+	s.pollUntil(hasStatus(s.iman, hostName1, consul_iman.InstanceStatusDead))
 
 	inses, err = s.iman.GetInstances()
 	require.NoError(t, err)
 	require.Len(t, inses, 0)
+
+	ins, err := s.iman.GetInstance(hostName1)
+	require.NoError(t, err)
+	require.Equal(t, consul_iman.InstanceStatusDead, ins.Status())
+}
+
+func (s *ImanTestSuite) TestIman_SelfEvents() {
+	t := s.T()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	defer cancel()
+
+	consulClient, err := s.consul.Client()
+	require.NoError(t, err)
+
+	events := make(chan consul_iman.Event, 10)
+	iman, err := consul_iman.NewClient(
+		serviceName,
+		consul_iman.WithDownHoldDuration(downHold),
+		consul_iman.WithPollInterval(pollInterval),
+		consul_iman.WithConsulClient(consulClient),
+		consul_iman.WithClock(s.clock),
+		consul_iman.WithSelf(hostName1),
+		consul_iman.WithEventHandler(func(ev consul_iman.Event) {
+			switch ev.Type {
+			case consul_iman.EventTypeSelfLost, consul_iman.EventTypeSelfEvicted, consul_iman.EventTypeSelfRecovered:
+				events <- ev
+			}
+		}),
+	)
+	require.NoError(t, err)
+
+	err = iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
+	go iman.Start(ctx)
+
+	err = iman.WaitReady(ctx)
+	require.NoError(t, err)
+
+	isLocal, err := iman.IsLocal("abc")
+	require.NoError(t, err)
+	require.True(t, isLocal)
+
+	err = iman.Deregister(hostName1)
+	require.NoError(t, err)
+
+	ev := s.pollEvent(events)
+	require.Equal(t, consul_iman.EventTypeSelfLost, ev.Type)
+	require.Equal(t, hostName1, ev.Instance.Name())
+
+	err = iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
+	ev = s.pollEvent(events)
+	require.Equal(t, consul_iman.EventTypeSelfRecovered, ev.Type)
+}
+
+func (s *ImanTestSuite) TestIman_LeaderElection() {
+	t := s.T()
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	consulClient, err := s.consul.Client()
+	require.NoError(t, err)
+
+	gained := make(chan struct{}, 1)
+	iman, err := consul_iman.NewClient(
+		serviceName,
+		consul_iman.WithPollInterval(pollInterval),
+		consul_iman.WithConsulClient(consulClient),
+		consul_iman.WithClock(s.clock),
+		consul_iman.WithSelf(hostName1),
+		consul_iman.WithLeaderElection(
+			func() { gained <- struct{}{} },
+			func() {},
+		),
+	)
+	require.NoError(t, err)
+
+	err = iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
+	go iman.Start(ctx)
+
+	err = iman.WaitReady(ctx)
+	require.NoError(t, err)
+
+	select {
+	case <-gained:
+	case <-time.After(time.Second * 5):
+		t.Fatal("leadership was not gained")
+	}
+
+	require.True(t, iman.IsLeader())
+	leader, err := iman.Leader()
+	require.NoError(t, err)
+	require.Equal(t, hostName1, leader.Name())
+}
+
+func (s *ImanTestSuite) TestIman_Lock() {
+	t := s.T()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*30)
+	defer cancel()
+
+	consulClient, err := s.consul.Client()
+	require.NoError(t, err)
+
+	iman, err := consul_iman.NewClient(
+		serviceName,
+		consul_iman.WithPollInterval(pollInterval),
+		consul_iman.WithConsulClient(consulClient),
+		consul_iman.WithClock(s.clock),
+		consul_iman.WithSelf(hostName1),
+	)
+	require.NoError(t, err)
+
+	err = iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
+	go iman.Start(ctx)
+
+	err = iman.WaitReady(ctx)
+	require.NoError(t, err)
+
+	_, err = iman.LockHolder("abc")
+	require.ErrorIs(t, err, consul_iman.ErrNotLocked)
+
+	lock, err := iman.Lock(ctx, "abc")
+	require.NoError(t, err)
+
+	holder, err := iman.LockHolder("abc")
+	require.NoError(t, err)
+	require.Equal(t, hostName1, holder.Name())
+
+	lockCtx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	startedAt := time.Now()
+	_, err = iman.Lock(lockCtx, "abc")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(startedAt), time.Second)
+
+	locks := make(chan *consul_iman.Lock, 1)
+	go func() {
+		lock, err := iman.Lock(ctx, "abc")
+		require.NoError(t, err)
+		locks <- lock
+	}()
+
+	err = lock.Unlock()
+	require.NoError(t, err)
+
+	// Waiting lock is acquired, when the key is released.
+	select {
+	case lock = <-locks:
+	case <-time.After(time.Second * 5):
+		t.Fatal("lock was not acquired after release")
+	}
+	select {
+	case <-lock.Lost():
+		t.Fatal("acquired lock is lost")
+	default:
+	}
+
+	err = lock.Unlock()
+	require.NoError(t, err)
+
+	<-lock.Lost()
+	_, err = iman.LockHolder("abc")
+	require.ErrorIs(t, err, consul_iman.ErrNotLocked)
+}
+
+func (s *ImanTestSuite) TestIman_LeaseFencingToken() {
+	t := s.T()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	defer cancel()
+
+	consulClient, err := s.consul.Client()
+	require.NoError(t, err)
+
+	iman, err := consul_iman.NewClient(
+		serviceName,
+		consul_iman.WithPollInterval(pollInterval),
+		consul_iman.WithConsulClient(consulClient),
+		consul_iman.WithClock(s.clock),
+		consul_iman.WithSelf(hostName1),
+	)
+	require.NoError(t, err)
+
+	err = iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+
+	go iman.Start(ctx)
+
+	err = iman.WaitReady(ctx)
+	require.NoError(t, err)
+
+	partition := iman.LeasePartition("abc")
+
+	lease, err := iman.AcquireLease(ctx, partition)
+	require.NoError(t, err)
+	require.Equal(t, partition, lease.Partition())
+
+	holder, err := iman.LeaseHolder(partition)
+	require.NoError(t, err)
+	require.Equal(t, hostName1, holder.Name())
+
+	err = lease.Release()
+	require.NoError(t, err)
+
+	nextLease, err := iman.AcquireLease(ctx, partition)
+	require.NoError(t, err)
+	require.Greater(t, nextLease.Token(), lease.Token())
+
+	err = nextLease.Release()
+	require.NoError(t, err)
+}
+
+func (s *ImanTestSuite) TestIman_LeaseLost() {
+	t := s.T()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	defer cancel()
+
+	consulClient, err := s.consul.Client()
+	require.NoError(t, err)
+
+	iman, err := consul_iman.NewClient(
+		serviceName,
+		consul_iman.WithPollInterval(pollInterval),
+		consul_iman.WithConsulClient(consulClient),
+		consul_iman.WithClock(s.clock),
+		consul_iman.WithSelf(hostName1),
+		consul_iman.WithLockDelay(time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	partition := iman.LeasePartition("abc")
+
+	lease, err := iman.AcquireLease(ctx, partition)
+	require.NoError(t, err)
+
+	s.consul.InvalidateSessions()
+
+	select {
+	case <-lease.Lost():
+	case <-ctx.Done():
+		t.Fatal("lease was not lost on session invalidation")
+	}
+
+	// Acquisition, rejected by lock delay, is retried by the clock.
+	leases := make(chan *consul_iman.Lease, 1)
+	go func() {
+		nextLease, err := iman.AcquireLease(ctx, partition)
+		require.NoError(t, err)
+		leases <- nextLease
+	}()
+
+	var nextLease *consul_iman.Lease
+	s.pollUntil(func() bool {
+		select {
+		case nextLease = <-leases:
+			return true
+		default:
+			return false
+		}
+	})
+	require.Greater(t, nextLease.Token(), lease.Token())
+
+	pair, _, err := consulClient.KV().Get(serviceName+"/leases/"+strconv.Itoa(partition), nil)
+	require.NoError(t, err)
+	require.Equal(t, pair.LockIndex, nextLease.Token())
+
+	require.NoError(t, lease.Release())
+	require.NoError(t, nextLease.Release())
 }
 
 func (s *ImanTestSuite) TestMultipleHashrings() {
 	t := s.T()
-	ctx := context.TODO()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	defer cancel()
 
-	consulCfg := api.DefaultConfig()
-	consulCfg.Address = consulAddr
-	consulClient, err := api.NewClient(consulCfg)
+	consulClient, err := s.consul.Client()
 	require.NoError(t, err)
 
 	iman, err := consul_iman.NewClient(
 		serviceName,
-		consul_iman.WithDownHoldDuration(time.Second),
-		consul_iman.WithPollInterval(time.Millisecond*500),
+		consul_iman.WithDownHoldDuration(downHold),
+		consul_iman.WithPollInterval(pollInterval),
 		consul_iman.WithConsulClient(consulClient),
+		consul_iman.WithClock(s.clock),
 		consul_iman.WithBackupHashring(func(b []byte) hashring.HashKey {
 			return hashKey(string(b))
 		}),
 	)
 	require.NoError(t, err)
 
-	go iman.Start(ctx)
-
 	err = s.iman.Register(hostName1, addr1)
 	require.NoError(t, err)
 	err = s.iman.Register(hostName2, addr1)
 	require.NoError(t, err)
-	time.Sleep(time.Millisecond * 1000)
+
+	go iman.Start(ctx)
+
+	err = iman.WaitReady(ctx)
+	require.NoError(t, err)
 
 	inses, err := iman.GetDataHolders("abc")
 	require.NoError(t, err)
@@ -179,20 +689,40 @@ func (s *ImanTestSuite) TestMultipleHashrings() {
 	require.Equal(t, inses[1].Name(), hostName2)
 }
 
+func (s *ImanTestSuite) TestGetDataHoldersBatch() {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*5)
+	defer cancel()
+	t := s.T()
+
+	err := s.iman.Register(hostName1, addr1)
+	require.NoError(t, err)
+	err = s.iman.Register(hostName2, addr2)
+	require.NoError(t, err)
+
+	go s.iman.Start(ctx)
+
+	err = s.iman.WaitReady(ctx)
+	require.NoError(t, err)
+
+	keys := []string{"abc", "def", "ghi", "jkl", "mno"}
+	plan, err := s.iman.GetDataHoldersBatch(keys)
+	require.NoError(t, err)
+
+	total := 0
+	for ins, group := range plan {
+		for _, key := range group {
+			holders, err := s.iman.GetDataHolders(key)
+			require.NoError(t, err)
+			require.Len(t, holders, 1)
+			require.Equal(t, holders[0].Name(), ins.Name())
+		}
+		total += len(group)
+	}
+	require.Equal(t, len(keys), total)
+}
+
 type hashKey string
 
 func (hk hashKey) Less(other hashring.HashKey) bool {
 	return hk < other.(hashKey)
 }
-
-func setupConsul() (testcontainers.Container, error) {
-	return testcontainers.GenericContainer(context.TODO(), testcontainers.GenericContainerRequest{
-		ContainerRequest: testcontainers.ContainerRequest{
-			Image:        "hashicorp/consul:latest",
-			ExposedPorts: []string{"8500:8500"},
-			Name:         "consul",
-			Hostname:     "consul",
-			Networks:     []string{"testnet"},
-		},
-	})
-}
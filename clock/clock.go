@@ -0,0 +1,73 @@
+package clock
+
+import (
+	"time"
+)
+
+// Source of current time, timers and tickers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Single event timer, like time.Timer.
+type Timer interface {
+	// Gets channel, that receives current time, when timer fires.
+	C() <-chan time.Time
+	// Prevents timer from firing.
+	// Returns false, if timer has already fired or been stopped.
+	Stop() bool
+}
+
+// Periodic timer, like time.Ticker.
+type Ticker interface {
+	// Gets channel, that receives current time on every tick.
+	// Ticks are dropped, if receiver is slow.
+	C() <-chan time.Time
+	// Turns off ticker.
+	Stop()
+}
+
+// Creates clock, that uses real time.
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (rt realTimer) C() <-chan time.Time {
+	return rt.t.C
+}
+
+func (rt realTimer) Stop() bool {
+	return rt.t.Stop()
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (rt realTicker) C() <-chan time.Time {
+	return rt.t.C
+}
+
+func (rt realTicker) Stop() {
+	rt.t.Stop()
+}
@@ -0,0 +1,185 @@
+package clocktest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/horockey/go-consul-instance-manager/clock"
+)
+
+var _ clock.Clock = (*Clock)(nil)
+
+// Fake clock for tests. Its time moves only by Advance.
+// Timers and tickers fire, when clock is advanced to their time.
+// Safe for concurrent use.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters map[*waiter]struct{}
+	// Closed and replaced, when set of waiters changes.
+	changed chan struct{}
+}
+
+// Creates fake clock, that starts at given time.
+func New(now time.Time) *Clock {
+	return &Clock{
+		now:     now,
+		waiters: map[*waiter]struct{}{},
+		changed: make(chan struct{}),
+	}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Creates timer, that fires, when clock is advanced by d.
+// Timer with non-positive d fires immediately.
+func (c *Clock) NewTimer(d time.Duration) clock.Timer {
+	w := &waiter{
+		clock: c,
+		ch:    make(chan time.Time, 1),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w.at = c.now.Add(d)
+	if d <= 0 {
+		w.ch <- c.now
+		return timer{w}
+	}
+
+	c.addWaiter(w)
+	return timer{w}
+}
+
+// Creates ticker, that ticks every time clock is advanced by d.
+// Panics, if d is not positive, like time.NewTicker.
+func (c *Clock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for clocktest.Clock.NewTicker")
+	}
+
+	w := &waiter{
+		clock:  c,
+		period: d,
+		ch:     make(chan time.Time, 1),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w.at = c.now.Add(d)
+	c.addWaiter(w)
+	return ticker{w}
+}
+
+// Moves clock forward by d and fires timers and tickers, that are due.
+// Ticker ticks once, even if several of its periods have passed.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for w := range c.waiters {
+		if w.at.After(c.now) {
+			continue
+		}
+
+		select {
+		case w.ch <- c.now:
+		default:
+		}
+
+		if w.period == 0 {
+			c.removeWaiter(w)
+			continue
+		}
+		for !w.at.After(c.now) {
+			w.at = w.at.Add(w.period)
+		}
+	}
+}
+
+// Blocks until at least n timers and tickers are waiting for the clock.
+// It is used to advance clock only after tested code has set up its timers.
+func (c *Clock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		waiting := len(c.waiters)
+		changed := c.changed
+		c.mu.Unlock()
+
+		if waiting >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// Gets count of timers and tickers, that are waiting for the clock.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+func (c *Clock) addWaiter(w *waiter) {
+	c.waiters[w] = struct{}{}
+	c.notifyChanged()
+}
+
+func (c *Clock) removeWaiter(w *waiter) bool {
+	if _, found := c.waiters[w]; !found {
+		return false
+	}
+
+	delete(c.waiters, w)
+	c.notifyChanged()
+	return true
+}
+
+func (c *Clock) notifyChanged() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Timer or ticker of fake clock.
+type waiter struct {
+	clock  *Clock
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+func (w *waiter) C() <-chan time.Time {
+	return w.ch
+}
+
+func (w *waiter) stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	return w.clock.removeWaiter(w)
+}
+
+type timer struct {
+	*waiter
+}
+
+func (t timer) Stop() bool {
+	return t.stop()
+}
+
+type ticker struct {
+	*waiter
+}
+
+func (t ticker) Stop() {
+	t.stop()
+}
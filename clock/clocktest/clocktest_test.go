@@ -0,0 +1,104 @@
+package clocktest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/horockey/go-consul-instance-manager/clock/clocktest"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func requireFired(t *testing.T, ch <-chan time.Time, expected time.Time) {
+	t.Helper()
+
+	select {
+	case at := <-ch:
+		require.True(t, expected.Equal(at), "fired at %s, expected %s", at, expected)
+	default:
+		t.Fatal("not fired")
+	}
+}
+
+func requireNotFired(t *testing.T, ch <-chan time.Time) {
+	t.Helper()
+
+	select {
+	case at := <-ch:
+		t.Fatalf("unexpectedly fired at %s", at)
+	default:
+	}
+}
+
+func TestTimer(t *testing.T) {
+	clk := clocktest.New(start)
+	timer := clk.NewTimer(time.Second)
+	require.Equal(t, 1, clk.Waiters())
+
+	clk.Advance(time.Millisecond * 999)
+	requireNotFired(t, timer.C())
+
+	clk.Advance(time.Millisecond)
+	requireFired(t, timer.C(), start.Add(time.Second))
+	require.Zero(t, clk.Waiters())
+
+	clk.Advance(time.Hour)
+	requireNotFired(t, timer.C())
+	require.False(t, timer.Stop())
+}
+
+func TestTimer_NonPositive(t *testing.T) {
+	clk := clocktest.New(start)
+	timer := clk.NewTimer(0)
+
+	requireFired(t, timer.C(), start)
+	require.Zero(t, clk.Waiters())
+}
+
+func TestTimer_Stop(t *testing.T) {
+	clk := clocktest.New(start)
+	timer := clk.NewTimer(time.Second)
+
+	require.True(t, timer.Stop())
+	require.False(t, timer.Stop())
+	require.Zero(t, clk.Waiters())
+
+	clk.Advance(time.Second)
+	requireNotFired(t, timer.C())
+}
+
+func TestTicker(t *testing.T) {
+	clk := clocktest.New(start)
+	ticker := clk.NewTicker(time.Second)
+
+	clk.Advance(time.Second)
+	requireFired(t, ticker.C(), start.Add(time.Second))
+
+	// Ticks of several periods are dropped into one, like real ticker does.
+	clk.Advance(time.Millisecond * 3500)
+	requireFired(t, ticker.C(), start.Add(time.Millisecond*4500))
+	requireNotFired(t, ticker.C())
+
+	clk.Advance(time.Millisecond * 500)
+	requireFired(t, ticker.C(), start.Add(time.Second*5))
+
+	ticker.Stop()
+	require.Zero(t, clk.Waiters())
+
+	clk.Advance(time.Second)
+	requireNotFired(t, ticker.C())
+}
+
+func TestBlockUntil(t *testing.T) {
+	clk := clocktest.New(start)
+
+	fired := make(chan time.Time)
+	go func() {
+		fired <- <-clk.NewTimer(time.Minute).C()
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	require.Equal(t, start.Add(time.Minute), <-fired)
+}
@@ -0,0 +1,40 @@
+package go_consul_instance_manager
+
+import (
+	"github.com/horockey/go-consul-instance-manager/internal/retrier"
+)
+
+// Returned by consul calls, while circuit breaker is open (see WithCircuitBreaker).
+var ErrCircuitOpen = retrier.ErrCircuitOpen
+
+// Counters of consul calls, made by client since its creation.
+type ConsulCallStats struct {
+	// Count of calls, each of them may take several attempts.
+	Calls uint64
+	// Count of attempts, made to consul.
+	Attempts uint64
+	// Count of attempts, made after failed ones.
+	Retries uint64
+	// Count of failed attempts.
+	Failures uint64
+	// Count of attempts, rejected by open circuit breaker.
+	Rejected uint64
+	// Count of circuit breaker openings.
+	BreakerOpened uint64
+	// Whether circuit breaker is open now.
+	BreakerOpen bool
+}
+
+// Get counters of consul calls, made by client.
+func (cl *Client) ConsulCallStats() ConsulCallStats {
+	m := cl.retrier.Metrics()
+	return ConsulCallStats{
+		Calls:         m.Calls,
+		Attempts:      m.Attempts,
+		Retries:       m.Retries,
+		Failures:      m.Failures,
+		Rejected:      m.Rejected,
+		BreakerOpened: m.BreakerOpened,
+		BreakerOpen:   m.BreakerOpen,
+	}
+}
@@ -0,0 +1,293 @@
+package consultest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// Address of the node, local agent of the server runs on.
+const agentAddress = "127.0.0.1"
+
+// Body of TTL check update request.
+type checkUpdate struct {
+	Status string
+	Output string
+}
+
+func (s *Server) registerAgent(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/agent/self", s.handleAgentSelf)
+	mux.HandleFunc("/v1/agent/services", s.handleAgentServices)
+	mux.HandleFunc("/v1/agent/checks", s.handleAgentChecks)
+	mux.HandleFunc("/v1/agent/service/register", s.handleAgentServiceRegister)
+	mux.HandleFunc("/v1/agent/service/deregister/", s.handleAgentServiceDeregister)
+	mux.HandleFunc("/v1/agent/check/pass/", s.handleAgentCheckStatus(consul.HealthPassing))
+	mux.HandleFunc("/v1/agent/check/warn/", s.handleAgentCheckStatus(consul.HealthWarning))
+	mux.HandleFunc("/v1/agent/check/fail/", s.handleAgentCheckStatus(consul.HealthCritical))
+	mux.HandleFunc("/v1/agent/check/update/", s.handleAgentCheckUpdate)
+}
+
+// Registers node of the local agent with its serf health check, like consul does on agent start.
+// Must be called under lock.
+func (s *Server) registerAgentNode() {
+	_ = s.register(&consul.CatalogRegistration{
+		Node:    AgentNode,
+		Address: agentAddress,
+		Check: &consul.AgentCheck{
+			CheckID: "serfHealth",
+			Name:    "Serf Health Status",
+			Status:  consul.HealthPassing,
+		},
+	})
+}
+
+func (s *Server) handleAgentSelf(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	s.mu.Lock()
+	nodeID := ""
+	if n, found := s.nodes[AgentNode]; found {
+		nodeID = n.ID
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]map[string]any{
+		"Config": {
+			"Datacenter": datacenter,
+			"NodeName":   AgentNode,
+			"NodeID":     nodeID,
+		},
+		"Member": {
+			"Name":   AgentNode,
+			"Addr":   agentAddress,
+			"Status": 1,
+		},
+	})
+}
+
+func (s *Server) handleAgentServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	s.mu.Lock()
+	services := map[string]consul.AgentService{}
+	if n, found := s.nodes[AgentNode]; found {
+		for id, svc := range n.services {
+			services[id] = *svc
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, services)
+}
+
+func (s *Server) handleAgentChecks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	s.mu.Lock()
+	checks := map[string]consul.AgentCheck{}
+	if n, found := s.nodes[AgentNode]; found {
+		for id, check := range n.checks {
+			checks[id] = consul.AgentCheck{
+				Node:        check.Node,
+				CheckID:     check.CheckID,
+				Name:        check.Name,
+				Status:      check.Status,
+				Notes:       check.Notes,
+				Output:      check.Output,
+				ServiceID:   check.ServiceID,
+				ServiceName: check.ServiceName,
+				Type:        check.Type,
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, checks)
+}
+
+func (s *Server) handleAgentServiceRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	reg := consul.AgentServiceRegistration{}
+	if err := decodeBody(r, &reg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if reg.Name == "" {
+		writeError(w, http.StatusBadRequest, "missing service name")
+		return
+	}
+
+	s.mu.Lock()
+	err := s.register(agentRegistration(&reg))
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleAgentServiceDeregister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	id := pathParam(r, "/v1/agent/service/deregister/")
+
+	s.mu.Lock()
+	n, found := s.nodes[AgentNode]
+	if found {
+		_, found = n.services[id]
+	}
+	if found {
+		s.deregister(AgentNode, id, "")
+	}
+	s.mu.Unlock()
+
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown service ID %q", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleAgentCheckStatus(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			writeMethodNotAllowed(w)
+			return
+		}
+
+		id := pathParam(r, "/v1/agent/check/"+statusPath(status)+"/")
+		s.updateAgentCheck(w, id, status, r.URL.Query().Get("note"))
+	}
+}
+
+func (s *Server) handleAgentCheckUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	id := pathParam(r, "/v1/agent/check/update/")
+
+	upd := checkUpdate{}
+	if err := decodeBody(r, &upd); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch upd.Status {
+	case consul.HealthPassing, consul.HealthWarning, consul.HealthCritical:
+	default:
+		writeError(w, http.StatusBadRequest, "invalid check status: "+upd.Status)
+		return
+	}
+
+	s.updateAgentCheck(w, id, upd.Status, upd.Output)
+}
+
+func (s *Server) updateAgentCheck(w http.ResponseWriter, id string, status string, output string) {
+	s.mu.Lock()
+	var check *consul.HealthCheck
+	if n, found := s.nodes[AgentNode]; found {
+		check = n.checks[id]
+	}
+	if check != nil {
+		check.Status = status
+		check.Output = output
+		check.ModifyIndex = s.commit(catalogTable)
+	}
+	s.mu.Unlock()
+
+	if check == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown check ID %q", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Converts service registration of the agent to catalog registration of the agent node.
+// Checks are not run: TTL checks are updated through the agent, others keep their initial status.
+func agentRegistration(reg *consul.AgentServiceRegistration) *consul.CatalogRegistration {
+	id := reg.ID
+	if id == "" {
+		id = reg.Name
+	}
+
+	svcChecks := reg.Checks
+	if reg.Check != nil {
+		svcChecks = append(consul.AgentServiceChecks{reg.Check}, svcChecks...)
+	}
+
+	checks := make(consul.HealthChecks, 0, len(svcChecks))
+	for idx, c := range svcChecks {
+		check := &consul.HealthCheck{
+			CheckID:   c.CheckID,
+			Name:      c.Name,
+			Status:    c.Status,
+			Notes:     c.Notes,
+			ServiceID: id,
+		}
+		if check.CheckID == "" {
+			check.CheckID = "service:" + id
+			if len(svcChecks) > 1 {
+				check.CheckID += ":" + strconv.Itoa(idx+1)
+			}
+		}
+		if check.Name == "" {
+			check.Name = "Service '" + reg.Name + "' check"
+		}
+		if c.TTL != "" {
+			check.Type = "ttl"
+		}
+		checks = append(checks, check)
+	}
+
+	return &consul.CatalogRegistration{
+		Node:           AgentNode,
+		SkipNodeUpdate: true,
+		Service: &consul.AgentService{
+			Kind:            reg.Kind,
+			ID:              id,
+			Service:         reg.Name,
+			Tags:            reg.Tags,
+			Meta:            reg.Meta,
+			Port:            reg.Port,
+			Address:         reg.Address,
+			TaggedAddresses: reg.TaggedAddresses,
+		},
+		Checks: checks,
+	}
+}
+
+// Gets path segment of TTL check update with given status.
+func statusPath(status string) string {
+	switch status {
+	case consul.HealthPassing:
+		return "pass"
+	case consul.HealthWarning:
+		return "warn"
+	default:
+		return "fail"
+	}
+}
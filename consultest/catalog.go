@@ -0,0 +1,330 @@
+package consultest
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/google/uuid"
+	consul "github.com/hashicorp/consul/api"
+)
+
+// Node of the catalog with its services and checks.
+type node struct {
+	consul.Node
+	// Services by ID.
+	services map[string]*consul.AgentService
+	// Checks by ID.
+	checks map[string]*consul.HealthCheck
+}
+
+func (s *Server) registerCatalog(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/catalog/register", s.handleCatalogRegister)
+	mux.HandleFunc("/v1/catalog/deregister", s.handleCatalogDeregister)
+	mux.HandleFunc("/v1/catalog/services", s.handleCatalogServices)
+	mux.HandleFunc("/v1/catalog/service/", s.handleCatalogService)
+	mux.HandleFunc("/v1/catalog/nodes", s.handleCatalogNodes)
+}
+
+func (s *Server) handleCatalogRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	reg := consul.CatalogRegistration{}
+	if err := decodeBody(r, &reg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	err := s.register(&reg)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, true)
+}
+
+func (s *Server) handleCatalogDeregister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	dereg := consul.CatalogDeregistration{}
+	if err := decodeBody(r, &dereg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dereg.Node == "" {
+		writeError(w, http.StatusBadRequest, "must provide node")
+		return
+	}
+
+	s.mu.Lock()
+	s.deregister(dereg.Node, dereg.ServiceID, dereg.CheckID)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, true)
+}
+
+func (s *Server) handleCatalogServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	s.serveRead(w, r, catalogTable, func() (any, int) {
+		services := map[string][]string{}
+		for _, n := range s.nodes {
+			for _, svc := range n.services {
+				tags := services[svc.Service]
+				if tags == nil {
+					tags = []string{}
+				}
+				for _, tag := range svc.Tags {
+					if !slices.Contains(tags, tag) {
+						tags = append(tags, tag)
+					}
+				}
+				services[svc.Service] = tags
+			}
+		}
+
+		return services, http.StatusOK
+	})
+}
+
+func (s *Server) handleCatalogService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	name := pathParam(r, "/v1/catalog/service/")
+	tags := r.URL.Query()["tag"]
+
+	s.serveRead(w, r, catalogTable, func() (any, int) {
+		entries := []*consul.CatalogService{}
+		for _, n := range s.sortedNodes() {
+			for _, svc := range n.sortedServices(name, tags) {
+				entries = append(entries, &consul.CatalogService{
+					ID:              n.ID,
+					Node:            n.Node.Node,
+					Address:         n.Address,
+					Datacenter:      datacenter,
+					TaggedAddresses: n.TaggedAddresses,
+					NodeMeta:        n.Meta,
+					ServiceID:       svc.ID,
+					ServiceName:     svc.Service,
+					ServiceAddress:  svc.Address,
+					ServiceTags:     svc.Tags,
+					ServiceMeta:     svc.Meta,
+					ServicePort:     svc.Port,
+					CreateIndex:     svc.CreateIndex,
+					ModifyIndex:     svc.ModifyIndex,
+					Checks:          n.serviceChecks(svc.ID),
+				})
+			}
+		}
+
+		return entries, http.StatusOK
+	})
+}
+
+func (s *Server) handleCatalogNodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	s.serveRead(w, r, catalogTable, func() (any, int) {
+		nodes := []consul.Node{}
+		for _, n := range s.sortedNodes() {
+			nodes = append(nodes, n.Node)
+		}
+
+		return nodes, http.StatusOK
+	})
+}
+
+// Registers node, its service and checks in the catalog.
+// Must be called under lock.
+func (s *Server) register(reg *consul.CatalogRegistration) error {
+	if reg.Node == "" {
+		return errors.New("must provide node")
+	}
+	if reg.Address == "" && !reg.SkipNodeUpdate {
+		return errors.New("must provide address")
+	}
+
+	checks := slices.Clone(reg.Checks)
+	if reg.Check != nil {
+		checks = append(checks, &consul.HealthCheck{
+			CheckID:   reg.Check.CheckID,
+			Name:      reg.Check.Name,
+			Status:    reg.Check.Status,
+			Notes:     reg.Check.Notes,
+			Output:    reg.Check.Output,
+			ServiceID: reg.Check.ServiceID,
+			Type:      reg.Check.Type,
+		})
+	}
+	for _, check := range checks {
+		if check.CheckID == "" && check.Name == "" {
+			return errors.New("must provide check ID or name")
+		}
+	}
+
+	idx := s.commit(catalogTable)
+
+	n, found := s.nodes[reg.Node]
+	if !found {
+		n = &node{
+			Node: consul.Node{
+				ID:          reg.ID,
+				Node:        reg.Node,
+				Datacenter:  datacenter,
+				CreateIndex: idx,
+			},
+			services: map[string]*consul.AgentService{},
+			checks:   map[string]*consul.HealthCheck{},
+		}
+		if n.ID == "" {
+			n.ID = uuid.NewString()
+		}
+		s.nodes[reg.Node] = n
+	}
+	if !reg.SkipNodeUpdate {
+		n.Address = reg.Address
+		n.TaggedAddresses = reg.TaggedAddresses
+		n.Meta = reg.NodeMeta
+		n.ModifyIndex = idx
+	}
+
+	if reg.Service != nil {
+		svc := *reg.Service
+		if svc.ID == "" {
+			svc.ID = svc.Service
+		}
+		svc.CreateIndex = idx
+		if prev, found := n.services[svc.ID]; found {
+			svc.CreateIndex = prev.CreateIndex
+		}
+		svc.ModifyIndex = idx
+		n.services[svc.ID] = &svc
+	}
+
+	for _, check := range checks {
+		hc := *check
+		hc.Node = reg.Node
+		if hc.CheckID == "" {
+			hc.CheckID = hc.Name
+		}
+		if hc.Status == "" {
+			hc.Status = consul.HealthCritical
+		}
+		if svc, found := n.services[hc.ServiceID]; found {
+			hc.ServiceName = svc.Service
+			hc.ServiceTags = svc.Tags
+		}
+		hc.CreateIndex = idx
+		if prev, found := n.checks[hc.CheckID]; found {
+			hc.CreateIndex = prev.CreateIndex
+		}
+		hc.ModifyIndex = idx
+		n.checks[hc.CheckID] = &hc
+	}
+
+	return nil
+}
+
+// Deregisters check, service with its checks or the whole node, if neither is given.
+// Must be called under lock.
+func (s *Server) deregister(nodeName string, serviceID string, checkID string) {
+	n, found := s.nodes[nodeName]
+	if !found {
+		return
+	}
+
+	s.commit(catalogTable)
+
+	switch {
+	case checkID != "":
+		delete(n.checks, checkID)
+	case serviceID != "":
+		delete(n.services, serviceID)
+		for id, check := range n.checks {
+			if check.ServiceID == serviceID {
+				delete(n.checks, id)
+			}
+		}
+	default:
+		delete(s.nodes, nodeName)
+	}
+}
+
+// Gets nodes, sorted by name.
+// Must be called under lock.
+func (s *Server) sortedNodes() []*node {
+	nodes := make([]*node, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Node.Node < nodes[j].Node.Node
+	})
+
+	return nodes
+}
+
+// Gets services of the node with given name and all given tags, sorted by ID.
+func (n *node) sortedServices(name string, tags []string) []*consul.AgentService {
+	services := []*consul.AgentService{}
+	for _, svc := range n.services {
+		if svc.Service != name {
+			continue
+		}
+
+		hasTags := true
+		for _, tag := range tags {
+			hasTags = hasTags && slices.Contains(svc.Tags, tag)
+		}
+		if hasTags {
+			services = append(services, svc)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ID < services[j].ID
+	})
+
+	return services
+}
+
+// Gets checks of the node itself and of its service with given ID, sorted by ID.
+// Unlike consul, catalog returns them with service entries, so health of the entry is known without extra request.
+func (n *node) serviceChecks(serviceID string) consul.HealthChecks {
+	checks := consul.HealthChecks{}
+	for _, check := range n.checks {
+		if check.ServiceID == "" || check.ServiceID == serviceID {
+			checks = append(checks, check)
+		}
+	}
+	sortChecks(checks)
+
+	return checks
+}
+
+func sortChecks(checks consul.HealthChecks) {
+	sort.Slice(checks, func(i, j int) bool {
+		if checks[i].Node != checks[j].Node {
+			return checks[i].Node < checks[j].Node
+		}
+		return checks[i].CheckID < checks[j].CheckID
+	})
+}
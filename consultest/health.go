@@ -0,0 +1,112 @@
+package consultest
+
+import (
+	"net/http"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+func (s *Server) registerHealth(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/health/service/", s.handleHealthService)
+	mux.HandleFunc("/v1/health/checks/", s.handleHealthChecks)
+	mux.HandleFunc("/v1/health/node/", s.handleHealthNode)
+	mux.HandleFunc("/v1/health/state/", s.handleHealthState)
+}
+
+func (s *Server) handleHealthService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	name := pathParam(r, "/v1/health/service/")
+	tags := r.URL.Query()["tag"]
+	passingOnly := hasParam(r, "passing")
+
+	s.serveRead(w, r, catalogTable, func() (any, int) {
+		entries := []*consul.ServiceEntry{}
+		for _, n := range s.sortedNodes() {
+			for _, svc := range n.sortedServices(name, tags) {
+				checks := n.serviceChecks(svc.ID)
+				if passingOnly && checks.AggregatedStatus() != consul.HealthPassing {
+					continue
+				}
+
+				entries = append(entries, &consul.ServiceEntry{
+					Node:    &n.Node,
+					Service: svc,
+					Checks:  checks,
+				})
+			}
+		}
+
+		return entries, http.StatusOK
+	})
+}
+
+func (s *Server) handleHealthChecks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	name := pathParam(r, "/v1/health/checks/")
+
+	s.serveRead(w, r, catalogTable, func() (any, int) {
+		return s.filterChecks(func(check *consul.HealthCheck) bool {
+			return check.ServiceName == name
+		}), http.StatusOK
+	})
+}
+
+func (s *Server) handleHealthNode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	nodeName := pathParam(r, "/v1/health/node/")
+
+	s.serveRead(w, r, catalogTable, func() (any, int) {
+		return s.filterChecks(func(check *consul.HealthCheck) bool {
+			return check.Node == nodeName
+		}), http.StatusOK
+	})
+}
+
+func (s *Server) handleHealthState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	state := pathParam(r, "/v1/health/state/")
+	switch state {
+	case consul.HealthAny, consul.HealthPassing, consul.HealthWarning, consul.HealthCritical, consul.HealthMaint:
+	default:
+		writeError(w, http.StatusBadRequest, "invalid check state: "+state)
+		return
+	}
+
+	s.serveRead(w, r, catalogTable, func() (any, int) {
+		return s.filterChecks(func(check *consul.HealthCheck) bool {
+			return state == consul.HealthAny || check.Status == state
+		}), http.StatusOK
+	})
+}
+
+// Gets checks of all nodes, that match given filter.
+// Must be called under lock.
+func (s *Server) filterChecks(match func(*consul.HealthCheck) bool) consul.HealthChecks {
+	checks := consul.HealthChecks{}
+	for _, n := range s.nodes {
+		for _, check := range n.checks {
+			if match(check) {
+				checks = append(checks, check)
+			}
+		}
+	}
+	sortChecks(checks)
+
+	return checks
+}
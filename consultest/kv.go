@@ -0,0 +1,247 @@
+package consultest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+var errInvalidSession = errors.New("invalid session")
+
+func (s *Server) registerKV(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/kv/", s.handleKV)
+}
+
+func (s *Server) handleKV(w http.ResponseWriter, r *http.Request) {
+	key := pathParam(r, "/v1/kv/")
+
+	switch r.Method {
+	case http.MethodGet:
+		s.handleKVGet(w, r, key)
+	case http.MethodPut:
+		s.handleKVPut(w, r, key)
+	case http.MethodDelete:
+		s.handleKVDelete(w, r, key)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (s *Server) handleKVGet(w http.ResponseWriter, r *http.Request, key string) {
+	keysOnly := hasParam(r, "keys")
+	recurse := hasParam(r, "recurse")
+	separator := r.URL.Query().Get("separator")
+
+	s.serveRead(w, r, kvTable, func() (any, int) {
+		switch {
+		case keysOnly:
+			keys := s.kvKeys(key, separator)
+			if len(keys) == 0 {
+				return nil, http.StatusNotFound
+			}
+			return keys, http.StatusOK
+		case recurse:
+			pairs := s.kvPairs(key)
+			if len(pairs) == 0 {
+				return nil, http.StatusNotFound
+			}
+			return pairs, http.StatusOK
+		default:
+			pair, found := s.kv[key]
+			if !found {
+				return nil, http.StatusNotFound
+			}
+			return consul.KVPairs{pair}, http.StatusOK
+		}
+	})
+}
+
+func (s *Server) handleKVPut(w http.ResponseWriter, r *http.Request, key string) {
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "missing key name")
+		return
+	}
+
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("reading request body: %s", err))
+		return
+	}
+
+	s.mu.Lock()
+	ok, err := s.putKV(key, value, r.URL.Query())
+	s.mu.Unlock()
+
+	switch {
+	case errors.Is(err, errInvalidSession):
+		writeError(w, http.StatusInternalServerError, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, ok)
+	}
+}
+
+func (s *Server) handleKVDelete(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+
+	var (
+		cas    uint64
+		hasCAS = query.Has("cas")
+	)
+	if hasCAS {
+		var err error
+		cas, err = strconv.ParseUint(query.Get("cas"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid cas index: %s", err))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	ok := s.deleteKV(key, query.Has("recurse"), hasCAS, cas)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, ok)
+}
+
+// Deletes the key or all keys with given prefix, if recurse is set.
+// With check-and-set the key is deleted only if its modify index matches cas.
+// Reports whether anything was deleted.
+// Must be called under lock.
+func (s *Server) deleteKV(key string, recurse bool, hasCAS bool, cas uint64) bool {
+	switch {
+	case recurse:
+		for k := range s.kv {
+			if strings.HasPrefix(k, key) {
+				delete(s.kv, k)
+			}
+		}
+	case hasCAS:
+		pair, found := s.kv[key]
+		if !found && cas != 0 || found && pair.ModifyIndex != cas {
+			return false
+		}
+		delete(s.kv, key)
+	default:
+		delete(s.kv, key)
+	}
+	s.commit(kvTable)
+
+	return true
+}
+
+// Writes value of the key according to the mode, set by query params:
+// plain write, check-and-set, lock acquisition or release.
+// Reports whether value was written.
+// Must be called under lock.
+func (s *Server) putKV(key string, value []byte, query url.Values) (bool, error) {
+	var flags uint64
+	if raw := query.Get("flags"); raw != "" {
+		var err error
+		flags, err = strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid flags: %w", err)
+		}
+	}
+
+	pair, found := s.kv[key]
+
+	switch {
+	case query.Has("acquire"):
+		id := query.Get("acquire")
+		if _, ok := s.sessions[id]; !ok {
+			return false, fmt.Errorf("%w: %s", errInvalidSession, id)
+		}
+		if found && pair.Session != "" && pair.Session != id {
+			return false, nil
+		}
+		if delayed, ok := s.lockDelays[key]; ok && s.clock.Now().Before(delayed) {
+			return false, nil
+		}
+	case query.Has("release"):
+		if !found || pair.Session != query.Get("release") {
+			return false, nil
+		}
+	case query.Has("cas"):
+		cas, err := strconv.ParseUint(query.Get("cas"), 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid cas index: %w", err)
+		}
+		if cas == 0 && found || cas != 0 && (!found || pair.ModifyIndex != cas) {
+			return false, nil
+		}
+	}
+
+	idx := s.commit(kvTable)
+	if !found {
+		pair = &consul.KVPair{
+			Key:         key,
+			CreateIndex: idx,
+		}
+		s.kv[key] = pair
+	}
+	pair.Value = value
+	pair.Flags = flags
+	pair.ModifyIndex = idx
+
+	switch {
+	case query.Has("acquire"):
+		if id := query.Get("acquire"); pair.Session != id {
+			pair.Session = id
+			pair.LockIndex++
+		}
+	case query.Has("release"):
+		pair.Session = ""
+	}
+
+	return true, nil
+}
+
+// Gets pairs with given prefix, sorted by key.
+// Must be called under lock.
+func (s *Server) kvPairs(prefix string) consul.KVPairs {
+	pairs := consul.KVPairs{}
+	for key, pair := range s.kv {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, pair)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	return pairs
+}
+
+// Gets sorted keys with given prefix.
+// If separator is set, keys are truncated after the first separator following the prefix.
+// Must be called under lock.
+func (s *Server) kvKeys(prefix string, separator string) []string {
+	keys := []string{}
+	for key := range s.kv {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if separator != "" {
+			if idx := strings.Index(key[len(prefix):], separator); idx >= 0 {
+				key = key[:len(prefix)+idx+len(separator)]
+			}
+		}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
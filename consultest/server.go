@@ -0,0 +1,402 @@
+package consultest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/horockey/go-consul-instance-manager/clock"
+	"github.com/horockey/go-toolbox/options"
+)
+
+const (
+	// Name of the node, local agent of the server runs on.
+	AgentNode = "consultest"
+
+	datacenter = "dc1"
+
+	defaultWait = time.Minute * 5
+	maxWait     = time.Minute * 10
+)
+
+// Tables of the state. Blocking queries are woken up by changes of the table, they read.
+type table int
+
+const (
+	catalogTable table = iota
+	kvTable
+	sessionsTable
+	tablesCount
+)
+
+// In-process fake of consul HTTP API.
+// It serves endpoints of catalog, health, agent, KV, sessions and status,
+// used by the client and applications built on it, including blocking queries.
+// State is kept in memory and lost on Close.
+// Safe for concurrent use.
+type Server struct {
+	srv   *httptest.Server
+	done  chan struct{}
+	clock clock.Clock
+
+	mu    sync.Mutex
+	index uint64
+	// Index of the last change of every table.
+	indexes [tablesCount]uint64
+	// Closed and replaced on every change, so blocking queries recheck their tables.
+	changed chan struct{}
+
+	nodes      map[string]*node
+	kv         map[string]*consul.KVPair
+	lockDelays map[string]time.Time
+	sessions   map[string]*session
+
+	outage     bool
+	leaderless bool
+}
+
+// Creates and starts fake server, listening on loopback interface.
+// Caller must call Close, when done.
+func NewServer(opts ...options.Option[Server]) (*Server, error) {
+	s := &Server{
+		done:       make(chan struct{}),
+		clock:      clock.New(),
+		index:      1,
+		changed:    make(chan struct{}),
+		nodes:      map[string]*node{},
+		kv:         map[string]*consul.KVPair{},
+		lockDelays: map[string]time.Time{},
+		sessions:   map[string]*session{},
+	}
+
+	if err := options.ApplyOptions(s, opts...); err != nil {
+		return nil, fmt.Errorf("applying opts: %w", err)
+	}
+
+	for t := range s.indexes {
+		s.indexes[t] = s.index
+	}
+	s.registerAgentNode()
+
+	mux := http.NewServeMux()
+	s.registerCatalog(mux)
+	s.registerHealth(mux)
+	s.registerAgent(mux)
+	s.registerKV(mux)
+	s.registerSessions(mux)
+	mux.HandleFunc("/v1/status/leader", s.handleStatusLeader)
+
+	s.srv = httptest.NewServer(s.middleware(mux))
+	return s, nil
+}
+
+// Stops the server. Blocking queries in progress are interrupted.
+func (s *Server) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	close(s.done)
+	s.mu.Unlock()
+
+	s.srv.Close()
+}
+
+// Gets base URL of the server, e.g. "http://127.0.0.1:1234".
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Gets address of the server in "host:port" form.
+func (s *Server) Addr() string {
+	return s.srv.Listener.Addr().String()
+}
+
+// Gets consul client config, pointing to the server.
+func (s *Server) Config() *consul.Config {
+	return &consul.Config{
+		Address: s.Addr(),
+		Scheme:  "http",
+	}
+}
+
+// Creates consul client, connected to the server.
+// It may be passed to WithConsulClient option.
+func (s *Server) Client() (*consul.Client, error) {
+	cl, err := consul.NewClient(s.Config())
+	if err != nil {
+		return nil, fmt.Errorf("creating consul client: %w", err)
+	}
+
+	return cl, nil
+}
+
+// Sets status of all checks of given node, e.g. consul.HealthCritical.
+// Change is seen by blocking queries as catalog update.
+func (s *Server) SetHealth(nodeName string, status string) error {
+	switch status {
+	case consul.HealthPassing, consul.HealthWarning, consul.HealthCritical, consul.HealthMaint:
+	default:
+		return fmt.Errorf("unknown health status: %s", status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, found := s.nodes[nodeName]
+	if !found {
+		return fmt.Errorf("node %q is not registered", nodeName)
+	}
+
+	idx := s.commit(catalogTable)
+	for _, check := range n.checks {
+		check.Status = status
+		check.ModifyIndex = idx
+	}
+
+	return nil
+}
+
+// Simulates outage of consul.
+// While it lasts, all requests, including blocking queries in progress,
+// fail with 503 Service Unavailable.
+func (s *Server) SetOutage(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outage = down
+	s.notifyChanged()
+}
+
+// Simulates loss of cluster leader.
+// Reads keep working, but report no known leader, like stale reads of consul do.
+func (s *Server) SetKnownLeader(known bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.leaderless = !known
+	s.notifyChanged()
+}
+
+// Invalidates given session, as if its TTL expired.
+// Locks, held by the session, are released or deleted according to its behavior.
+func (s *Server) InvalidateSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.sessions[id]; !found {
+		return fmt.Errorf("session %q is not found", id)
+	}
+
+	s.invalidateSession(id)
+	return nil
+}
+
+// Invalidates all sessions, as if consul lost them.
+func (s *Server) InvalidateSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.sessions {
+		s.invalidateSession(id)
+	}
+}
+
+// Increments index and marks given tables as changed by it.
+// Must be called under lock.
+func (s *Server) commit(tables ...table) uint64 {
+	s.index++
+	for _, t := range tables {
+		s.indexes[t] = s.index
+	}
+	s.notifyChanged()
+
+	return s.index
+}
+
+// Wakes up blocking queries.
+// Must be called under lock.
+func (s *Server) notifyChanged() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Rejects requests during outage.
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		outage := s.outage
+		s.mu.Unlock()
+
+		if outage {
+			writeUnavailable(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Serves read of given table.
+// If request is a blocking query, it waits until index of the table exceeds requested one or wait time passes.
+// read is called under lock and returns response body and status, nil body writes no content.
+func (s *Server) serveRead(w http.ResponseWriter, r *http.Request, t table, read func() (any, int)) {
+	waitIndex, wait, err := parseBlocking(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var timeout <-chan time.Time
+	if waitIndex > 0 {
+		timer := s.clock.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	timedOut := false
+	for {
+		s.mu.Lock()
+		if s.outage {
+			s.mu.Unlock()
+			writeUnavailable(w)
+			return
+		}
+
+		idx := s.indexes[t]
+		if waitIndex == 0 || idx > waitIndex || timedOut {
+			body, status := read()
+			knownLeader := !s.leaderless
+			// Body refers to the state, so it is encoded before unlock.
+			raw, err := json.Marshal(body)
+			s.mu.Unlock()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if body == nil {
+				raw = nil
+			}
+
+			w.Header().Set("X-Consul-Index", strconv.FormatUint(idx, 10))
+			w.Header().Set("X-Consul-KnownLeader", strconv.FormatBool(knownLeader))
+			w.Header().Set("X-Consul-LastContact", "0")
+			writeJSON(w, status, json.RawMessage(raw))
+			return
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			timedOut = true
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			writeUnavailable(w)
+			return
+		}
+	}
+}
+
+func (s *Server) handleStatusLeader(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	s.mu.Lock()
+	leader := s.srv.Listener.Addr().String()
+	if s.leaderless {
+		leader = ""
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, leader)
+}
+
+// Parses index and wait params of blocking query.
+func parseBlocking(r *http.Request) (uint64, time.Duration, error) {
+	query := r.URL.Query()
+
+	var index uint64
+	if raw := query.Get("index"); raw != "" {
+		var err error
+		index, err = strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid index: %w", err)
+		}
+	}
+
+	wait := defaultWait
+	if raw := query.Get("wait"); raw != "" {
+		var err error
+		wait, err = time.ParseDuration(raw)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid wait: %w", err)
+		}
+		wait = min(wait, maxWait)
+	}
+
+	return index, wait, nil
+}
+
+// Decodes JSON body of the request into v.
+// Empty body leaves v untouched.
+func decodeBody(r *http.Request, v any) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("decoding request body: %w", err)
+	}
+
+	return nil
+}
+
+// Gets the last segment of the path after given prefix.
+func pathParam(r *http.Request, prefix string) string {
+	return strings.TrimPrefix(r.URL.Path, prefix)
+}
+
+// Reports whether flag param (e.g. ?recurse) is set.
+func hasParam(r *http.Request, name string) bool {
+	_, found := r.URL.Query()[name]
+	return found
+}
+
+// Writes body as JSON. Nil body writes no content.
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	if raw, ok := body.(json.RawMessage); body == nil || ok && raw == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(msg))
+}
+
+func writeUnavailable(w http.ResponseWriter) {
+	writeError(w, http.StatusServiceUnavailable, "consul is unavailable")
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeError(w, http.StatusMethodNotAllowed, "method is not allowed")
+}
@@ -0,0 +1,20 @@
+package consultest
+
+import (
+	"errors"
+
+	"github.com/horockey/go-consul-instance-manager/clock"
+	"github.com/horockey/go-toolbox/options"
+)
+
+// Sets clock, used for session TTLs, lock delays and wait time of blocking queries.
+// Default is real time clock.
+func WithClock(clk clock.Clock) options.Option[Server] {
+	return func(target *Server) error {
+		if clk == nil {
+			return errors.New("got nil clock")
+		}
+		target.clock = clk
+		return nil
+	}
+}
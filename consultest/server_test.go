@@ -0,0 +1,435 @@
+package consultest_test
+
+import (
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+
+	"github.com/horockey/go-consul-instance-manager/clock/clocktest"
+	"github.com/horockey/go-consul-instance-manager/consultest"
+)
+
+const serviceName = "test_service"
+
+func newTestClient(t *testing.T) (*consultest.Server, *consul.Client, *clocktest.Clock) {
+	t.Helper()
+
+	clk := clocktest.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	srv, err := consultest.NewServer(consultest.WithClock(clk))
+	require.NoError(t, err)
+	t.Cleanup(srv.Close)
+
+	cl, err := srv.Client()
+	require.NoError(t, err)
+
+	return srv, cl, clk
+}
+
+func register(t *testing.T, cl *consul.Client, nodeName string) {
+	t.Helper()
+
+	_, err := cl.Catalog().Register(&consul.CatalogRegistration{
+		Node:    nodeName,
+		Address: nodeName + ":8080",
+		Service: &consul.AgentService{
+			ID:      serviceName + "_" + nodeName,
+			Service: serviceName,
+		},
+		Checks: consul.HealthChecks{
+			{Node: nodeName, CheckID: nodeName + "_check", Status: consul.HealthPassing},
+		},
+	}, nil)
+	require.NoError(t, err)
+}
+
+func TestCatalog_RegisterDeregister(t *testing.T) {
+	_, cl, _ := newTestClient(t)
+
+	register(t, cl, "host1")
+	register(t, cl, "host2")
+
+	entries, meta, err := cl.Catalog().Service(serviceName, "", nil)
+	require.NoError(t, err)
+	require.NotZero(t, meta.LastIndex)
+	require.Len(t, entries, 2)
+	require.Equal(t, "host1", entries[0].Node)
+	require.Equal(t, "host1:8080", entries[0].Address)
+	require.Equal(t, serviceName+"_host1", entries[0].ServiceID)
+	require.Equal(t, consul.HealthPassing, entries[0].Checks.AggregatedStatus())
+
+	_, err = cl.Catalog().Deregister(&consul.CatalogDeregistration{
+		Node:      "host1",
+		ServiceID: serviceName + "_host1",
+	}, nil)
+	require.NoError(t, err)
+
+	entries, _, err = cl.Catalog().Service(serviceName, "", nil)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "host2", entries[0].Node)
+}
+
+func TestSetHealth(t *testing.T) {
+	srv, cl, _ := newTestClient(t)
+
+	register(t, cl, "host1")
+	register(t, cl, "host2")
+
+	require.NoError(t, srv.SetHealth("host1", consul.HealthCritical))
+	require.Error(t, srv.SetHealth("unknown", consul.HealthCritical))
+	require.Error(t, srv.SetHealth("host1", "unknown"))
+
+	entries, _, err := cl.Catalog().Service(serviceName, "", nil)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, consul.HealthCritical, entries[0].Checks.AggregatedStatus())
+	require.Equal(t, consul.HealthPassing, entries[1].Checks.AggregatedStatus())
+
+	passing, _, err := cl.Health().Service(serviceName, "", true, nil)
+	require.NoError(t, err)
+	require.Len(t, passing, 1)
+	require.Equal(t, "host2", passing[0].Node.Node)
+}
+
+func TestBlockingQuery(t *testing.T) {
+	srv, cl, _ := newTestClient(t)
+
+	register(t, cl, "host1")
+
+	_, meta, err := cl.Catalog().Service(serviceName, "", nil)
+	require.NoError(t, err)
+
+	type result struct {
+		entries []*consul.CatalogService
+		meta    *consul.QueryMeta
+		err     error
+	}
+	results := make(chan result, 1)
+	go func() {
+		entries, meta, err := cl.Catalog().Service(serviceName, "", &consul.QueryOptions{
+			WaitIndex: meta.LastIndex,
+			WaitTime:  time.Second * 5,
+		})
+		results <- result{entries, meta, err}
+	}()
+
+	// Writes to other tables do not wake the query up.
+	_, err = cl.KV().Put(&consul.KVPair{Key: "abc"}, nil)
+	require.NoError(t, err)
+	select {
+	case <-results:
+		t.Fatal("query returned before catalog change")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	require.NoError(t, srv.SetHealth("host1", consul.HealthCritical))
+
+	select {
+	case res := <-results:
+		require.NoError(t, res.err)
+		require.Greater(t, res.meta.LastIndex, meta.LastIndex)
+		require.Len(t, res.entries, 1)
+		require.Equal(t, consul.HealthCritical, res.entries[0].Checks.AggregatedStatus())
+	case <-time.After(time.Second):
+		t.Fatal("query was not woken up by catalog change")
+	}
+}
+
+func TestBlockingQuery_Timeout(t *testing.T) {
+	_, cl, clk := newTestClient(t)
+
+	_, meta, err := cl.KV().List("", nil)
+	require.NoError(t, err)
+
+	type result struct {
+		meta *consul.QueryMeta
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		_, meta, err := cl.KV().List("", &consul.QueryOptions{
+			WaitIndex: meta.LastIndex,
+			WaitTime:  time.Second * 10,
+		})
+		results <- result{meta, err}
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second * 10)
+
+	select {
+	case res := <-results:
+		require.NoError(t, res.err)
+		require.Equal(t, meta.LastIndex, res.meta.LastIndex)
+	case <-time.After(time.Second):
+		t.Fatal("query did not time out")
+	}
+}
+
+func TestKV(t *testing.T) {
+	_, cl, _ := newTestClient(t)
+	kv := cl.KV()
+
+	pair, _, err := kv.Get("a/b", nil)
+	require.NoError(t, err)
+	require.Nil(t, pair)
+
+	_, err = kv.Put(&consul.KVPair{Key: "a/b", Value: []byte("1")}, nil)
+	require.NoError(t, err)
+	_, err = kv.Put(&consul.KVPair{Key: "a/c/d", Value: []byte("2")}, nil)
+	require.NoError(t, err)
+
+	pair, _, err = kv.Get("a/b", nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), pair.Value)
+
+	keys, _, err := kv.Keys("a/", "/", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/b", "a/c/"}, keys)
+
+	ok, _, err := kv.CAS(&consul.KVPair{Key: "a/b", Value: []byte("3"), ModifyIndex: pair.ModifyIndex - 1}, nil)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, _, err = kv.CAS(&consul.KVPair{Key: "a/b", Value: []byte("3"), ModifyIndex: pair.ModifyIndex}, nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = kv.DeleteTree("a/", nil)
+	require.NoError(t, err)
+
+	pairs, _, err := kv.List("a/", nil)
+	require.NoError(t, err)
+	require.Empty(t, pairs)
+}
+
+func TestKV_AcquireRelease(t *testing.T) {
+	srv, cl, clk := newTestClient(t)
+	kv := cl.KV()
+
+	sess1, _, err := cl.Session().Create(nil, nil)
+	require.NoError(t, err)
+	sess2, _, err := cl.Session().Create(nil, nil)
+	require.NoError(t, err)
+
+	ok, _, err := kv.Acquire(&consul.KVPair{Key: "lock", Session: sess1}, nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, _, err = kv.Acquire(&consul.KVPair{Key: "lock", Session: sess2}, nil)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	pair, _, err := kv.Get("lock", nil)
+	require.NoError(t, err)
+	require.Equal(t, sess1, pair.Session)
+	require.Equal(t, uint64(1), pair.LockIndex)
+
+	require.NoError(t, srv.InvalidateSession(sess1))
+
+	pair, _, err = kv.Get("lock", nil)
+	require.NoError(t, err)
+	require.Empty(t, pair.Session)
+
+	// Default lock delay is over, see TestKV_LockDelay.
+	clk.Advance(time.Second * 15)
+
+	ok, _, err = kv.Acquire(&consul.KVPair{Key: "lock", Session: sess2}, nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, _, err = kv.Release(&consul.KVPair{Key: "lock", Session: sess2}, nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	pair, _, err = kv.Get("lock", nil)
+	require.NoError(t, err)
+	require.Empty(t, pair.Session)
+	require.Equal(t, uint64(2), pair.LockIndex)
+
+	_, _, err = kv.Acquire(&consul.KVPair{Key: "lock", Session: sess1}, nil)
+	require.Error(t, err)
+}
+
+func TestKV_LockDelay(t *testing.T) {
+	srv, cl, clk := newTestClient(t)
+	kv := cl.KV()
+
+	sess1, _, err := cl.Session().Create(&consul.SessionEntry{LockDelay: time.Second * 10}, nil)
+	require.NoError(t, err)
+	sess2, _, err := cl.Session().Create(nil, nil)
+	require.NoError(t, err)
+
+	ok, _, err := kv.Acquire(&consul.KVPair{Key: "lock", Session: sess1}, nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.NoError(t, srv.InvalidateSession(sess1))
+
+	ok, _, err = kv.Acquire(&consul.KVPair{Key: "lock", Session: sess2}, nil)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	clk.Advance(time.Second*10 - time.Nanosecond)
+
+	ok, _, err = kv.Acquire(&consul.KVPair{Key: "lock", Session: sess2}, nil)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	clk.Advance(time.Nanosecond)
+
+	ok, _, err = kv.Acquire(&consul.KVPair{Key: "lock", Session: sess2}, nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestSession_TTL(t *testing.T) {
+	_, cl, clk := newTestClient(t)
+
+	id, _, err := cl.Session().Create(&consul.SessionEntry{TTL: "10s"}, nil)
+	require.NoError(t, err)
+
+	ok, _, err := cl.KV().Acquire(&consul.KVPair{Key: "lock", Session: id}, nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Like consul, session outlives its TTL until twice of it.
+	clk.BlockUntil(1)
+	clk.Advance(time.Second * 10)
+
+	entry, _, err := cl.Session().Info(id, nil)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+
+	clk.Advance(time.Second * 10)
+
+	require.Eventually(t, func() bool {
+		entry, _, err := cl.Session().Info(id, nil)
+		return err == nil && entry == nil
+	}, time.Second, time.Millisecond*10)
+
+	pair, _, err := cl.KV().Get("lock", nil)
+	require.NoError(t, err)
+	require.Empty(t, pair.Session)
+}
+
+func TestLock(t *testing.T) {
+	srv, cl, _ := newTestClient(t)
+
+	lock, err := cl.LockOpts(&consul.LockOptions{
+		Key:          "lock",
+		LockWaitTime: time.Second,
+	})
+	require.NoError(t, err)
+
+	lost, err := lock.Lock(nil)
+	require.NoError(t, err)
+	require.NotNil(t, lost)
+
+	srv.InvalidateSessions()
+
+	select {
+	case <-lost:
+	case <-time.After(time.Second * 5):
+		t.Fatal("lock was not lost on session invalidation")
+	}
+}
+
+func TestAgent(t *testing.T) {
+	_, cl, _ := newTestClient(t)
+	agent := cl.Agent()
+
+	nodeName, err := agent.NodeName()
+	require.NoError(t, err)
+	require.Equal(t, consultest.AgentNode, nodeName)
+
+	err = agent.ServiceRegister(&consul.AgentServiceRegistration{
+		ID:    "svc1",
+		Name:  serviceName,
+		Port:  8080,
+		Check: &consul.AgentServiceCheck{TTL: "10s"},
+	})
+	require.NoError(t, err)
+
+	services, err := agent.Services()
+	require.NoError(t, err)
+	require.Contains(t, services, "svc1")
+
+	passing, _, err := cl.Health().Service(serviceName, "", true, nil)
+	require.NoError(t, err)
+	require.Empty(t, passing)
+
+	err = agent.UpdateTTL("service:svc1", "ok", consul.HealthPassing)
+	require.NoError(t, err)
+
+	passing, _, err = cl.Health().Service(serviceName, "", true, nil)
+	require.NoError(t, err)
+	require.Len(t, passing, 1)
+	require.Equal(t, consultest.AgentNode, passing[0].Node.Node)
+
+	err = agent.ServiceDeregister("svc1")
+	require.NoError(t, err)
+
+	entries, _, err := cl.Catalog().Service(serviceName, "", nil)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestSetOutage(t *testing.T) {
+	srv, cl, clk := newTestClient(t)
+
+	register(t, cl, "host1")
+
+	_, meta, err := cl.Catalog().Service(serviceName, "", nil)
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := cl.Catalog().Service(serviceName, "", &consul.QueryOptions{
+			WaitIndex: meta.LastIndex,
+			WaitTime:  time.Second * 5,
+		})
+		errs <- err
+	}()
+
+	clk.BlockUntil(1)
+
+	srv.SetOutage(true)
+
+	select {
+	case err := <-errs:
+		require.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("blocking query was not interrupted by outage")
+	}
+
+	_, _, err = cl.Catalog().Service(serviceName, "", nil)
+	require.Error(t, err)
+
+	srv.SetOutage(false)
+
+	entries, _, err := cl.Catalog().Service(serviceName, "", nil)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestSetKnownLeader(t *testing.T) {
+	srv, cl, _ := newTestClient(t)
+
+	_, meta, err := cl.Catalog().Nodes(nil)
+	require.NoError(t, err)
+	require.True(t, meta.KnownLeader)
+
+	srv.SetKnownLeader(false)
+
+	_, meta, err = cl.Catalog().Nodes(nil)
+	require.NoError(t, err)
+	require.False(t, meta.KnownLeader)
+
+	leader, err := cl.Status().Leader()
+	require.NoError(t, err)
+	require.Empty(t, leader)
+}
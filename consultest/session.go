@@ -0,0 +1,309 @@
+package consultest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	consul "github.com/hashicorp/consul/api"
+)
+
+// Lock delay of sessions, that do not set it, like in consul.
+const defaultLockDelay = time.Second * 15
+
+type session struct {
+	entry consul.SessionEntry
+	// Closed to cancel pending expiration, when session is renewed or invalidated.
+	cancelExpiration chan struct{}
+}
+
+func (sess *session) stopExpiration() {
+	if sess.cancelExpiration != nil {
+		close(sess.cancelExpiration)
+		sess.cancelExpiration = nil
+	}
+}
+
+// Body of session creation request.
+type sessionRequest struct {
+	Name          string
+	Node          string
+	LockDelay     json.RawMessage
+	Behavior      string
+	TTL           string
+	Checks        []string
+	NodeChecks    []string
+	ServiceChecks []consul.ServiceCheck
+}
+
+func (s *Server) registerSessions(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/session/create", s.handleSessionCreate)
+	mux.HandleFunc("/v1/session/destroy/", s.handleSessionDestroy)
+	mux.HandleFunc("/v1/session/renew/", s.handleSessionRenew)
+	mux.HandleFunc("/v1/session/info/", s.handleSessionInfo)
+	mux.HandleFunc("/v1/session/node/", s.handleSessionNode)
+	mux.HandleFunc("/v1/session/list", s.handleSessionList)
+}
+
+func (s *Server) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	req := sessionRequest{}
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entry, err := newSessionEntry(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.createSession(entry)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"ID": entry.ID})
+}
+
+func (s *Server) handleSessionDestroy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	id := pathParam(r, "/v1/session/destroy/")
+
+	s.mu.Lock()
+	if _, found := s.sessions[id]; found {
+		s.invalidateSession(id)
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, true)
+}
+
+func (s *Server) handleSessionRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	id := pathParam(r, "/v1/session/renew/")
+
+	s.mu.Lock()
+	sess, found := s.sessions[id]
+	if found {
+		s.scheduleExpiration(sess)
+	}
+	s.mu.Unlock()
+
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("session %q not found", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, []consul.SessionEntry{sess.entry})
+}
+
+func (s *Server) handleSessionInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	id := pathParam(r, "/v1/session/info/")
+
+	s.serveRead(w, r, sessionsTable, func() (any, int) {
+		entries := []consul.SessionEntry{}
+		if sess, found := s.sessions[id]; found {
+			entries = append(entries, sess.entry)
+		}
+
+		return entries, http.StatusOK
+	})
+}
+
+func (s *Server) handleSessionNode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	nodeName := pathParam(r, "/v1/session/node/")
+
+	s.serveRead(w, r, sessionsTable, func() (any, int) {
+		return s.sessionEntries(nodeName), http.StatusOK
+	})
+}
+
+func (s *Server) handleSessionList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	s.serveRead(w, r, sessionsTable, func() (any, int) {
+		return s.sessionEntries(""), http.StatusOK
+	})
+}
+
+// Stores new session and starts its expiration.
+// Must be called under lock.
+func (s *Server) createSession(entry consul.SessionEntry) {
+	entry.CreateIndex = s.commit(sessionsTable)
+
+	sess := &session{entry: entry}
+	s.sessions[entry.ID] = sess
+	s.scheduleExpiration(sess)
+}
+
+// (Re)starts expiration of the session with TTL.
+// Like consul, session is invalidated after twice its TTL.
+// Must be called under lock.
+func (s *Server) scheduleExpiration(sess *session) {
+	ttl, _ := time.ParseDuration(sess.entry.TTL)
+	if ttl <= 0 {
+		return
+	}
+
+	sess.stopExpiration()
+
+	cancel := make(chan struct{})
+	sess.cancelExpiration = cancel
+
+	timer := s.clock.NewTimer(ttl * 2)
+	go func() {
+		defer timer.Stop()
+
+		select {
+		case <-timer.C():
+		case <-cancel:
+			return
+		case <-s.done:
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// Session may be invalidated or renewed after timer fired.
+		if s.sessions[sess.entry.ID] == sess && sess.cancelExpiration == cancel {
+			s.invalidateSession(sess.entry.ID)
+		}
+	}()
+}
+
+// Removes session and releases or deletes its locks according to its behavior.
+// Released keys can not be acquired during lock delay of the session.
+// Must be called under lock.
+func (s *Server) invalidateSession(id string) {
+	sess := s.sessions[id]
+	sess.stopExpiration()
+	delete(s.sessions, id)
+
+	idx := s.commit(sessionsTable, kvTable)
+	for key, pair := range s.kv {
+		if pair.Session != id {
+			continue
+		}
+
+		if sess.entry.Behavior == consul.SessionBehaviorDelete {
+			delete(s.kv, key)
+		} else {
+			pair.Session = ""
+			pair.ModifyIndex = idx
+		}
+
+		if sess.entry.LockDelay > 0 {
+			s.lockDelays[key] = s.clock.Now().Add(sess.entry.LockDelay)
+		}
+	}
+}
+
+// Gets sessions of given node or all sessions, if node is empty, sorted by creation.
+// Must be called under lock.
+func (s *Server) sessionEntries(nodeName string) []consul.SessionEntry {
+	entries := []consul.SessionEntry{}
+	for _, sess := range s.sessions {
+		if nodeName == "" || sess.entry.Node == nodeName {
+			entries = append(entries, sess.entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreateIndex < entries[j].CreateIndex
+	})
+
+	return entries
+}
+
+func newSessionEntry(req sessionRequest) (consul.SessionEntry, error) {
+	entry := consul.SessionEntry{
+		ID:            uuid.NewString(),
+		Name:          req.Name,
+		Node:          req.Node,
+		LockDelay:     defaultLockDelay,
+		Behavior:      req.Behavior,
+		TTL:           req.TTL,
+		Checks:        req.Checks,
+		NodeChecks:    req.NodeChecks,
+		ServiceChecks: req.ServiceChecks,
+	}
+
+	if entry.Node == "" {
+		entry.Node = AgentNode
+	}
+
+	switch entry.Behavior {
+	case "":
+		entry.Behavior = consul.SessionBehaviorRelease
+	case consul.SessionBehaviorRelease, consul.SessionBehaviorDelete:
+	default:
+		return consul.SessionEntry{}, fmt.Errorf("invalid behavior: %s", entry.Behavior)
+	}
+
+	if entry.TTL != "" {
+		if ttl, err := time.ParseDuration(entry.TTL); err != nil || ttl <= 0 {
+			return consul.SessionEntry{}, fmt.Errorf("invalid TTL: %s", entry.TTL)
+		}
+	}
+
+	if len(req.LockDelay) > 0 {
+		delay, err := parseLockDelay(req.LockDelay)
+		if err != nil {
+			return consul.SessionEntry{}, err
+		}
+		entry.LockDelay = delay
+	}
+
+	return entry, nil
+}
+
+// Parses lock delay, given as duration string or number.
+// Like consul, small numbers are treated as seconds and large ones as nanoseconds.
+func parseLockDelay(raw json.RawMessage) (time.Duration, error) {
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		delay, err := time.ParseDuration(str)
+		if err != nil {
+			return 0, fmt.Errorf("invalid lock delay: %w", err)
+		}
+		return delay, nil
+	}
+
+	var num int64
+	if err := json.Unmarshal(raw, &num); err != nil {
+		return 0, fmt.Errorf("invalid lock delay: %s", string(raw))
+	}
+	if num < 1000 {
+		return time.Duration(num) * time.Second, nil
+	}
+	return time.Duration(num), nil
+}
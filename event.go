@@ -0,0 +1,8 @@
+package go_consul_instance_manager
+
+// Notification about change, observed by client.
+// Instance is nil for events, that are not related to particular instance.
+type Event struct {
+	Type     EventType
+	Instance *Instance
+}
@@ -0,0 +1,6 @@
+package go_consul_instance_manager
+
+//go:generate go-enum
+
+// ENUM(self_lost, self_evicted, self_recovered, instance_up, instance_down, instance_evicted, instance_purged, instance_degraded, panic_started, panic_ended, instance_updated)
+type EventType uint8
@@ -0,0 +1,60 @@
+package go_consul_instance_manager
+
+import (
+	"time"
+)
+
+// Creates hold resolver, that reads hold duration of the instance from its service meta.
+// Value must be parsable by time.ParseDuration, e.g. "1m30s".
+// Instances without valid positive value get default hold duration.
+func DownHoldFromMeta(key string) func(*Instance) time.Duration {
+	return func(ins *Instance) time.Duration {
+		dur, err := time.ParseDuration(ins.meta[key])
+		if err != nil {
+			return 0
+		}
+
+		return dur
+	}
+}
+
+// Resolves duration, for which given instance is held pending.
+// Must be called from the goroutine, that modifies topology.
+func (cl *Client) resolveHold(ins *Instance, now time.Time) time.Duration {
+	hold := cl.holdDur
+	if cl.holdResolver != nil {
+		if dur := cl.holdResolver(ins); dur > 0 {
+			hold = dur
+		}
+	}
+
+	cl.recentDowns = cl.pruneRecentDowns(now)
+	cl.recentDowns = append(cl.recentDowns, now)
+
+	if cl.maxAdaptiveHold <= hold {
+		return hold
+	}
+
+	// Other instances, gone down recently, are treated as rolling deployment.
+	// Hold is lengthened proportionally to give them time to return.
+	if others := len(cl.recentDowns) - 1; others > 0 {
+		hold = min(hold*time.Duration(others+1), cl.maxAdaptiveHold)
+	}
+
+	// Instance, that used to recover, gets enough time to do it again.
+	if ins.recoveries > 0 {
+		hold = max(hold, min(ins.meanRecovery*2, cl.maxAdaptiveHold))
+	}
+
+	return hold
+}
+
+// Drops downs, that are older than default hold duration.
+func (cl *Client) pruneRecentDowns(now time.Time) []time.Time {
+	idx := 0
+	for idx < len(cl.recentDowns) && now.Sub(cl.recentDowns[idx]) > cl.holdDur {
+		idx++
+	}
+
+	return cl.recentDowns[idx:]
+}
@@ -1,9 +1,84 @@
 package go_consul_instance_manager
 
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+)
+
 type Instance struct {
-	name    string
-	address string
-	status  InstanceStatus
+	name           string
+	address        string
+	serviceAddress string
+	servicePort    int
+	tags           []string
+	meta           map[string]string
+	status         InstanceStatus
+
+	firstSeen       time.Time
+	statusChangedAt time.Time
+	evictAt         time.Time
+	// Time of the last transition to pending status.
+	// Kept while instance is dead, so time of its return is known.
+	wentDownAt time.Time
+
+	// Unix nanoseconds. Updated on every scan, while instance is alive.
+	lastSeenHealthy atomic.Int64
+
+	// Count and mean duration of returns after eviction.
+	recoveries   uint
+	meanRecovery time.Duration
+
+	// Incremented on every status change.
+	// Used to detect timers, scheduled for previous states.
+	gen uint64
+}
+
+// Creates instance with given status, inheriting history of prev one.
+// prev may be nil for never seen instance.
+func newInstance(prev *Instance, src model.Instance, status InstanceStatus, now time.Time) *Instance {
+	ins := &Instance{
+		name:            src.Name,
+		address:         src.Address,
+		serviceAddress:  src.ServiceAddress,
+		servicePort:     src.ServicePort,
+		tags:            src.Tags,
+		meta:            src.Meta,
+		status:          status,
+		firstSeen:       now,
+		statusChangedAt: now,
+	}
+
+	if prev != nil {
+		ins.firstSeen = prev.firstSeen
+		ins.lastSeenHealthy.Store(prev.lastSeenHealthy.Load())
+		ins.wentDownAt = prev.wentDownAt
+		ins.recoveries = prev.recoveries
+		ins.meanRecovery = prev.meanRecovery
+		ins.gen = prev.gen + 1
+		if prev.status == status {
+			ins.statusChangedAt = prev.statusChangedAt
+			ins.evictAt = prev.evictAt
+			ins.gen = prev.gen
+		}
+	}
+	if status == InstanceStatusPending && (prev == nil || prev.status != InstanceStatusPending) {
+		ins.wentDownAt = now
+	}
+
+	return ins
+}
+
+func (ins *Instance) source() model.Instance {
+	return model.Instance{
+		Name:           ins.name,
+		Address:        ins.address,
+		ServiceAddress: ins.serviceAddress,
+		ServicePort:    ins.servicePort,
+		Tags:           ins.tags,
+		Meta:           ins.meta,
+	}
 }
 
 func (ins *Instance) Name() string {
@@ -14,6 +89,73 @@ func (ins *Instance) Address() string {
 	return ins.address
 }
 
+// Get address of the service.
+// Empty address means, that service is reachable at node Address.
+func (ins *Instance) ServiceAddress() string {
+	return ins.serviceAddress
+}
+
+// Get port of the service.
+func (ins *Instance) ServicePort() int {
+	return ins.servicePort
+}
+
+// Get service tags of the instance.
+// Returned slice must not be modified.
+func (ins *Instance) Tags() []string {
+	return ins.tags
+}
+
+// Get service meta of the instance.
+// Returned map must not be modified.
+func (ins *Instance) Meta() map[string]string {
+	return ins.meta
+}
+
 func (ins *Instance) Status() InstanceStatus {
 	return ins.status
 }
+
+// Get time, when instance was seen for the first time.
+func (ins *Instance) FirstSeen() time.Time {
+	return ins.firstSeen
+}
+
+// Get time of the last change of instance status.
+func (ins *Instance) StatusChangedAt() time.Time {
+	return ins.statusChangedAt
+}
+
+// Get time, when instance was seen healthy in consul for the last time.
+func (ins *Instance) LastSeenHealthy() time.Time {
+	nsec := ins.lastSeenHealthy.Load()
+	if nsec == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nsec)
+}
+
+// Get time, when pending instance is going to be evicted.
+// Zero time is returned for instance, that is not pending.
+func (ins *Instance) EvictAt() time.Time {
+	return ins.evictAt
+}
+
+// Get count of recoveries of the instance, that took longer than hold:
+// instance was evicted as dead and returned later.
+// Returns within hold are not counted, since they need no longer hold.
+func (ins *Instance) Recoveries() uint {
+	return ins.recoveries
+}
+
+// Get mean time from going pending till return of the instance after eviction.
+// Zero is returned for instance, that has never recovered after eviction.
+func (ins *Instance) MeanRecovery() time.Duration {
+	return ins.meanRecovery
+}
+
+func (ins *Instance) recordRecovery(dur time.Duration) {
+	ins.recoveries++
+	ins.meanRecovery += (dur - ins.meanRecovery) / time.Duration(ins.recoveries)
+}
@@ -0,0 +1,38 @@
+package go_consul_instance_manager
+
+import "slices"
+
+// Predicate to select instances.
+type InstanceFilter func(ins *Instance) bool
+
+// Selects instances with any of given statuses.
+func HasStatus(statuses ...InstanceStatus) InstanceFilter {
+	return func(ins *Instance) bool {
+		return slices.Contains(statuses, ins.status)
+	}
+}
+
+// Selects instances with given service tag.
+func HasTag(tag string) InstanceFilter {
+	return func(ins *Instance) bool {
+		return slices.Contains(ins.tags, tag)
+	}
+}
+
+// Selects instances with given service meta key-value pair.
+func HasMeta(key string, value string) InstanceFilter {
+	return func(ins *Instance) bool {
+		v, found := ins.meta[key]
+		return found && v == value
+	}
+}
+
+func matchFilters(ins *Instance, filters []InstanceFilter) bool {
+	for _, filter := range filters {
+		if !filter(ins) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,88 @@
+package go_consul_instance_manager
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Observation, that may change instance status.
+type instanceInput uint8
+
+const (
+	// Instance is seen healthy in consul.
+	instanceInputPassing instanceInput = iota
+	// Instance is seen in consul with warning health status.
+	instanceInputWarning
+	// Instance is not seen healthy in consul anymore.
+	instanceInputFailing
+	// Hold period of pending instance is over.
+	instanceInputHoldExpired
+	// Tombstone retention of dead instance is over.
+	instanceInputRetentionExpired
+)
+
+var (
+	// Input is not applicable to current instance state.
+	errInvalidTransition = errors.New("invalid instance transition")
+	// Input was produced for previous state of the instance and must be ignored.
+	errStaleInput = errors.New("stale instance input")
+)
+
+// Result of instance state machine step.
+type instanceTransition struct {
+	to InstanceStatus
+	// Instance must be removed from instances list.
+	forget bool
+}
+
+// Calculates next state of the instance.
+// ins is nil for unknown instance.
+// gen is generation of instance state, timer inputs were scheduled for.
+//
+//	unknown  --passing--> alive
+//	unknown  --warning--> degraded
+//	alive    --warning--> degraded
+//	alive    --failing--> pending
+//	degraded --passing--> alive
+//	degraded --failing--> pending
+//	pending  --passing/warning--> alive/degraded
+//	pending  --hold expired--> dead
+//	dead     --passing/warning--> alive/degraded
+//	dead     --retention expired--> unknown
+func transit(ins *Instance, in instanceInput, gen uint64) (instanceTransition, error) {
+	switch in {
+	case instanceInputPassing:
+		return instanceTransition{to: InstanceStatusAlive}, nil
+
+	case instanceInputWarning:
+		return instanceTransition{to: InstanceStatusDegraded}, nil
+
+	case instanceInputFailing:
+		if ins == nil || (ins.status != InstanceStatusAlive && ins.status != InstanceStatusDegraded) {
+			return instanceTransition{}, fmt.Errorf("%w: %s is failing", errInvalidTransition, statusOf(ins))
+		}
+		return instanceTransition{to: InstanceStatusPending}, nil
+
+	case instanceInputHoldExpired:
+		if ins == nil || ins.status != InstanceStatusPending || ins.gen != gen {
+			return instanceTransition{}, fmt.Errorf("%w: hold expired for %s", errStaleInput, statusOf(ins))
+		}
+		return instanceTransition{to: InstanceStatusDead}, nil
+
+	case instanceInputRetentionExpired:
+		if ins == nil || ins.status != InstanceStatusDead || ins.gen != gen {
+			return instanceTransition{}, fmt.Errorf("%w: retention expired for %s", errStaleInput, statusOf(ins))
+		}
+		return instanceTransition{to: InstanceStatusDead, forget: true}, nil
+	}
+
+	return instanceTransition{}, fmt.Errorf("%w: unknown input %d", errInvalidTransition, in)
+}
+
+func statusOf(ins *Instance) string {
+	if ins == nil {
+		return "unknown instance"
+	}
+
+	return ins.status.String() + " instance"
+}
@@ -0,0 +1,372 @@
+package go_consul_instance_manager
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/horockey/go-consul-instance-manager/clock"
+	"github.com/horockey/go-consul-instance-manager/clock/clocktest"
+	"github.com/horockey/go-consul-instance-manager/internal/healthchecker"
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+	"github.com/horockey/go-consul-instance-manager/internal/pending_instances_holder"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransit(t *testing.T) {
+	withStatus := func(status InstanceStatus, gen uint64) *Instance {
+		return &Instance{name: "host1", status: status, gen: gen}
+	}
+
+	testCases := []struct {
+		name     string
+		ins      *Instance
+		input    instanceInput
+		gen      uint64
+		expected instanceTransition
+		err      error
+	}{
+		{"unknown passing", nil, instanceInputPassing, 0, instanceTransition{to: InstanceStatusAlive}, nil},
+		{"unknown warning", nil, instanceInputWarning, 0, instanceTransition{to: InstanceStatusDegraded}, nil},
+		{"unknown failing", nil, instanceInputFailing, 0, instanceTransition{}, errInvalidTransition},
+		{"unknown hold expired", nil, instanceInputHoldExpired, 0, instanceTransition{}, errStaleInput},
+		{"unknown retention expired", nil, instanceInputRetentionExpired, 0, instanceTransition{}, errStaleInput},
+
+		{"alive passing", withStatus(InstanceStatusAlive, 1), instanceInputPassing, 0, instanceTransition{to: InstanceStatusAlive}, nil},
+		{"alive warning", withStatus(InstanceStatusAlive, 1), instanceInputWarning, 0, instanceTransition{to: InstanceStatusDegraded}, nil},
+		{"alive failing", withStatus(InstanceStatusAlive, 1), instanceInputFailing, 0, instanceTransition{to: InstanceStatusPending}, nil},
+		{"alive hold expired", withStatus(InstanceStatusAlive, 1), instanceInputHoldExpired, 1, instanceTransition{}, errStaleInput},
+		{"alive retention expired", withStatus(InstanceStatusAlive, 1), instanceInputRetentionExpired, 1, instanceTransition{}, errStaleInput},
+
+		{"degraded passing", withStatus(InstanceStatusDegraded, 1), instanceInputPassing, 0, instanceTransition{to: InstanceStatusAlive}, nil},
+		{"degraded warning", withStatus(InstanceStatusDegraded, 1), instanceInputWarning, 0, instanceTransition{to: InstanceStatusDegraded}, nil},
+		{"degraded failing", withStatus(InstanceStatusDegraded, 1), instanceInputFailing, 0, instanceTransition{to: InstanceStatusPending}, nil},
+		{"degraded hold expired", withStatus(InstanceStatusDegraded, 1), instanceInputHoldExpired, 1, instanceTransition{}, errStaleInput},
+
+		{"pending passing", withStatus(InstanceStatusPending, 2), instanceInputPassing, 0, instanceTransition{to: InstanceStatusAlive}, nil},
+		{"pending warning", withStatus(InstanceStatusPending, 2), instanceInputWarning, 0, instanceTransition{to: InstanceStatusDegraded}, nil},
+		{"pending failing", withStatus(InstanceStatusPending, 2), instanceInputFailing, 0, instanceTransition{}, errInvalidTransition},
+		{"pending hold expired", withStatus(InstanceStatusPending, 2), instanceInputHoldExpired, 2, instanceTransition{to: InstanceStatusDead}, nil},
+		{"pending stale hold expired", withStatus(InstanceStatusPending, 4), instanceInputHoldExpired, 2, instanceTransition{}, errStaleInput},
+		{"pending retention expired", withStatus(InstanceStatusPending, 2), instanceInputRetentionExpired, 2, instanceTransition{}, errStaleInput},
+
+		{"dead passing", withStatus(InstanceStatusDead, 3), instanceInputPassing, 0, instanceTransition{to: InstanceStatusAlive}, nil},
+		{"dead warning", withStatus(InstanceStatusDead, 3), instanceInputWarning, 0, instanceTransition{to: InstanceStatusDegraded}, nil},
+		{"dead failing", withStatus(InstanceStatusDead, 3), instanceInputFailing, 0, instanceTransition{}, errInvalidTransition},
+		{"dead hold expired", withStatus(InstanceStatusDead, 3), instanceInputHoldExpired, 2, instanceTransition{}, errStaleInput},
+		{"dead retention expired", withStatus(InstanceStatusDead, 3), instanceInputRetentionExpired, 3, instanceTransition{to: InstanceStatusDead, forget: true}, nil},
+		{"dead stale retention expired", withStatus(InstanceStatusDead, 5), instanceInputRetentionExpired, 3, instanceTransition{}, errStaleInput},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := transit(tc.ins, tc.input, tc.gen)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, tr)
+		})
+	}
+}
+
+func newTestClientWithHolders(t *testing.T, holdDur time.Duration) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cl := newTestClient()
+	cl.holdDur = holdDur
+	cl.tombstoneRetention = time.Hour
+	cl.clock = clocktest.New(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	var err error
+	cl.pih, err = pending_instances_holder.New(holdDur, cl.clock)
+	require.NoError(t, err)
+	cl.tombstones, err = pending_instances_holder.New(cl.tombstoneRetention, cl.clock)
+	require.NoError(t, err)
+
+	go func() { _ = cl.pih.Start(ctx) }()
+	go func() { _ = cl.tombstones.Start(ctx) }()
+
+	return cl
+}
+
+func TestApplyInput_RecoveryCancelsEviction(t *testing.T) {
+	holdDur := time.Millisecond * 200
+	cl := newTestClientWithHolders(t, holdDur)
+	src := model.Instance{Name: "host1", Address: "http://host1:8080"}
+
+	cl.applyInput(src, instanceInputPassing, 0)
+	cl.applyInput(src, instanceInputFailing, 0)
+
+	ins, err := cl.GetInstance(src.Name)
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusPending, ins.Status())
+
+	cl.applyInput(src, instanceInputPassing, 0)
+
+	// Marker, held for longer period, is emitted after the cancelled eviction would be.
+	marker := model.Instance{Name: "marker"}
+	require.NoError(t, cl.pih.AddFor(marker, 0, holdDur*2))
+	cl.clock.(*clocktest.Clock).Advance(holdDur * 2)
+
+	<-cl.pih.Out().Notify()
+	require.Equal(t, []model.InstanceChange{{Instance: marker, IsDown: true}}, drainQueue(cl.pih.Out()))
+
+	ins, err = cl.GetInstance(src.Name)
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusAlive, ins.Status())
+	// Recovery within hold needs no longer hold, so it is not recorded.
+	require.Zero(t, ins.Recoveries())
+}
+
+func TestApplyInput_EvictionAfterHold(t *testing.T) {
+	holdDur := time.Second * 15
+	cl := newTestClientWithHolders(t, holdDur)
+	clk := cl.clock.(*clocktest.Clock)
+	src := model.Instance{Name: "host1", Address: "http://host1:8080"}
+
+	cl.applyInput(src, instanceInputPassing, 0)
+	cl.applyInput(src, instanceInputFailing, 0)
+
+	clk.Advance(holdDur)
+	<-cl.pih.Out().Notify()
+	cl.applyTimerInputs(drainQueue(cl.pih.Out()), instanceInputHoldExpired)
+
+	ins, err := cl.GetInstance(src.Name)
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusDead, ins.Status())
+	require.True(t, clk.Now().Equal(ins.LastSeenHealthy().Add(holdDur)))
+
+	// Return after eviction is recorded with time since going pending.
+	clk.Advance(time.Second * 5)
+	cl.applyInput(src, instanceInputPassing, 0)
+
+	ins, err = cl.GetInstance(src.Name)
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusAlive, ins.Status())
+	require.Equal(t, uint(1), ins.Recoveries())
+	require.Equal(t, holdDur+time.Second*5, ins.MeanRecovery())
+}
+
+func TestApplyInput_StaleEvictionIgnored(t *testing.T) {
+	cl := newTestClientWithHolders(t, time.Hour)
+	src := model.Instance{Name: "host1", Address: "http://host1:8080"}
+
+	cl.applyInput(src, instanceInputPassing, 0)
+	cl.applyInput(src, instanceInputFailing, 0)
+	firstGen := cl.instances[src.Name].gen
+
+	cl.applyInput(src, instanceInputPassing, 0)
+	cl.applyInput(src, instanceInputFailing, 0)
+
+	// Eviction, scheduled for the first pending period.
+	cl.applyInput(src, instanceInputHoldExpired, firstGen)
+
+	ins, err := cl.GetInstance(src.Name)
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusPending, ins.Status())
+
+	holders, err := cl.GetDataHolders("abc")
+	require.NoError(t, err)
+	require.Equal(t, []*Instance{ins}, holders)
+
+	cl.applyInput(src, instanceInputHoldExpired, ins.gen)
+
+	ins, err = cl.GetInstance(src.Name)
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusDead, ins.Status())
+
+	_, err = cl.GetDataHolders("abc")
+	require.Error(t, err)
+}
+
+func TestApplyInput_DegradedHashringsPolicy(t *testing.T) {
+	for _, keep := range []bool{true, false} {
+		cl := newTestClientWithHolders(t, time.Hour)
+		cl.degradedInHashrings = keep
+
+		host1 := model.Instance{Name: "host1", Address: "http://host1:8080"}
+		host2 := model.Instance{Name: "host2", Address: "http://host2:8080"}
+		cl.applyInput(host1, instanceInputPassing, 0)
+		cl.applyInput(host2, instanceInputPassing, 0)
+
+		host1.Degraded = true
+		cl.applyInput(host1, instanceInputWarning, 0)
+
+		ins, err := cl.GetInstance(host1.Name)
+		require.NoError(t, err)
+		require.Equal(t, InstanceStatusDegraded, ins.Status())
+
+		degradedHolds := false
+		for id := 0; id < 100; id++ {
+			holders, err := GetDataHoldersOf(cl, id)
+			require.NoError(t, err)
+			degradedHolds = degradedHolds || holders[0].Name() == host1.Name
+		}
+		require.Equal(t, keep, degradedHolds)
+	}
+}
+
+func TestHandlePanic_DefersEvictions(t *testing.T) {
+	cl := newTestClientWithHolders(t, time.Hour)
+	cl.healthChecker = healthchecker.New(nil, nil, "app", time.Second, healthchecker.DampingConfig{}, healthchecker.PanicConfig{}, clock.New(), zerolog.Nop())
+
+	host1 := model.Instance{Name: "host1", Address: "http://host1:8080"}
+	host2 := model.Instance{Name: "host2", Address: "http://host2:8080"}
+	for _, src := range []model.Instance{host1, host2} {
+		cl.applyInput(src, instanceInputPassing, 0)
+		cl.applyInput(src, instanceInputFailing, 0)
+	}
+
+	events := []EventType{}
+	cl.eventHandler = func(ev Event) { events = append(events, ev.Type) }
+
+	cl.handlePanic(true)
+	require.True(t, cl.Panicking())
+
+	// Hold of both instances expires during panic, host1 comes back before its end.
+	for _, src := range []model.Instance{host1, host2} {
+		cl.deferredEvictions = append(cl.deferredEvictions, model.InstanceChange{
+			Instance:   src,
+			IsDown:     true,
+			Generation: cl.instances[src.Name].gen,
+		})
+	}
+	cl.healthChecker.Out().Push(model.ChangeSet{
+		Changes:   []model.InstanceChange{{Instance: host1}},
+		ScannedAt: time.Now(),
+	})
+
+	cl.handlePanic(false)
+	require.False(t, cl.Panicking())
+	require.Empty(t, cl.deferredEvictions)
+
+	ins, err := cl.GetInstance(host1.Name)
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusAlive, ins.Status())
+
+	ins, err = cl.GetInstance(host2.Name)
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusDead, ins.Status())
+
+	require.Equal(t, EventTypePanicStarted, events[0])
+	require.Equal(t, EventTypePanicEnded, events[len(events)-1])
+}
+
+func TestApplyInput_AddressChange(t *testing.T) {
+	cl := newTestClientWithHolders(t, time.Hour)
+
+	host1 := model.Instance{Name: "host1", Address: "http://host1:8080"}
+	host2 := model.Instance{Name: "host2", Address: "http://host2:8080"}
+	cl.applyInput(host1, instanceInputPassing, 0)
+	cl.applyInput(host2, instanceInputPassing, 0)
+
+	holdersBefore := map[int]string{}
+	for id := 0; id < 100; id++ {
+		holders, err := GetDataHoldersOf(cl, id)
+		require.NoError(t, err)
+		holdersBefore[id] = holders[0].Name()
+	}
+
+	events := []Event{}
+	cl.eventHandler = func(ev Event) { events = append(events, ev) }
+
+	host1.Address = "http://host1:9090"
+	cl.applyInput(host1, instanceInputPassing, 0)
+
+	require.Len(t, events, 1)
+	require.Equal(t, EventTypeInstanceUpdated, events[0].Type)
+	require.Equal(t, host1.Address, events[0].Instance.Address())
+	require.Equal(t, InstanceStatusAlive, events[0].Instance.Status())
+
+	for id := 0; id < 100; id++ {
+		holders, err := GetDataHoldersOf(cl, id)
+		require.NoError(t, err)
+		require.Equal(t, holdersBefore[id], holders[0].Name())
+	}
+}
+
+func TestApplyInput_PortChangeWithStatusChange(t *testing.T) {
+	cl := newTestClientWithHolders(t, time.Hour)
+
+	host1 := model.Instance{Name: "host1", Address: "10.0.0.1", ServicePort: 8080}
+	cl.applyInput(host1, instanceInputWarning, 0)
+
+	events := []Event{}
+	cl.eventHandler = func(ev Event) { events = append(events, ev) }
+
+	// Instance recovers from degradation on new port.
+	host1.ServicePort = 9090
+	cl.applyInput(host1, instanceInputPassing, 0)
+
+	require.Len(t, events, 2)
+	require.Equal(t, EventTypeInstanceUpdated, events[0].Type)
+	require.Equal(t, 9090, events[0].Instance.ServicePort())
+	require.Equal(t, EventTypeInstanceUp, events[1].Type)
+	require.Equal(t, InstanceStatusAlive, events[1].Instance.Status())
+}
+
+func TestApplyInputs_SinglePublish(t *testing.T) {
+	cl := newTestClientWithHolders(t, time.Hour)
+
+	inputs := []inputEvent{}
+	for idx := 0; idx < 50; idx++ {
+		name := "host" + strconv.Itoa(idx)
+		inputs = append(inputs, inputEvent{
+			src: model.Instance{Name: name, Address: "http://" + name + ":8080"},
+			in:  instanceInputPassing,
+		})
+	}
+
+	// Every event is delivered after the whole batch is published.
+	upEvents := 0
+	cl.eventHandler = func(ev Event) {
+		require.Equal(t, EventTypeInstanceUp, ev.Type)
+		upEvents++
+
+		inses, err := cl.GetInstances()
+		require.NoError(t, err)
+		require.Len(t, inses, len(inputs))
+		require.Equal(t, len(inputs), cl.hashrings[0].Size())
+	}
+
+	cl.applyInputs(inputs)
+	require.Equal(t, len(inputs), upEvents)
+}
+
+func TestHandleChangeSet_Ready(t *testing.T) {
+	cl := newTestClientWithHolders(t, time.Hour)
+	scannedAt := time.Now()
+
+	cl.handleChangeSet(model.ChangeSet{
+		Changes: []model.InstanceChange{
+			{Instance: model.Instance{Name: "host1", Address: "http://host1:8080"}},
+			{Instance: model.Instance{Name: "host2", Address: "http://host2:8080", Degraded: true}},
+		},
+		ScannedAt: scannedAt,
+	})
+
+	select {
+	case <-cl.Ready():
+	default:
+		t.Fatal("client is not ready after the first change set")
+	}
+
+	host1, err := cl.GetInstance("host1")
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusAlive, host1.Status())
+	require.True(t, scannedAt.Equal(host1.LastSeenHealthy()))
+
+	host2, err := cl.GetInstance("host2")
+	require.NoError(t, err)
+	require.Equal(t, InstanceStatusDegraded, host2.Status())
+
+	// Empty change set of the next scan is applied too.
+	cl.handleChangeSet(model.ChangeSet{ScannedAt: scannedAt.Add(time.Second)})
+	require.True(t, scannedAt.Add(time.Second).Equal(host1.LastSeenHealthy()))
+}
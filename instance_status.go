@@ -2,5 +2,5 @@ package go_consul_instance_manager
 
 //go:generate go-enum
 
-// ENUM(alive, pending)
+// ENUM(alive, pending, dead, degraded)
 type InstanceStatus uint8
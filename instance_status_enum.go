@@ -16,15 +16,21 @@ const (
 	InstanceStatusAlive InstanceStatus = iota
 	// InstanceStatusPending is a InstanceStatus of type Pending.
 	InstanceStatusPending
+	// InstanceStatusDead is a InstanceStatus of type Dead.
+	InstanceStatusDead
+	// InstanceStatusDegraded is a InstanceStatus of type Degraded.
+	InstanceStatusDegraded
 )
 
 var ErrInvalidInstanceStatus = errors.New("not a valid InstanceStatus")
 
-const _InstanceStatusName = "alivepending"
+const _InstanceStatusName = "alivependingdeaddegraded"
 
 var _InstanceStatusMap = map[InstanceStatus]string{
-	InstanceStatusAlive:   _InstanceStatusName[0:5],
-	InstanceStatusPending: _InstanceStatusName[5:12],
+	InstanceStatusAlive:    _InstanceStatusName[0:5],
+	InstanceStatusPending:  _InstanceStatusName[5:12],
+	InstanceStatusDead:     _InstanceStatusName[12:16],
+	InstanceStatusDegraded: _InstanceStatusName[16:24],
 }
 
 // String implements the Stringer interface.
@@ -43,8 +49,10 @@ func (x InstanceStatus) IsValid() bool {
 }
 
 var _InstanceStatusValue = map[string]InstanceStatus{
-	_InstanceStatusName[0:5]:  InstanceStatusAlive,
-	_InstanceStatusName[5:12]: InstanceStatusPending,
+	_InstanceStatusName[0:5]:   InstanceStatusAlive,
+	_InstanceStatusName[5:12]:  InstanceStatusPending,
+	_InstanceStatusName[12:16]: InstanceStatusDead,
+	_InstanceStatusName[16:24]: InstanceStatusDegraded,
 }
 
 // ParseInstanceStatus attempts to convert a string to a InstanceStatus.
@@ -0,0 +1,39 @@
+package go_consul_instance_manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewInstance_History(t *testing.T) {
+	src := model.Instance{Name: "host1", Address: "http://host1:8080"}
+	t0 := time.Now()
+
+	ins := newInstance(nil, src, InstanceStatusAlive, t0)
+	ins.lastSeenHealthy.Store(t0.UnixNano())
+	require.Equal(t, t0, ins.FirstSeen())
+	require.Equal(t, t0, ins.StatusChangedAt())
+	require.True(t, ins.EvictAt().IsZero())
+
+	t1 := t0.Add(time.Second)
+	refreshed := newInstance(ins, src, InstanceStatusAlive, t1)
+	require.Equal(t, t0, refreshed.FirstSeen())
+	require.Equal(t, t0, refreshed.StatusChangedAt())
+
+	t2 := t1.Add(time.Second)
+	pending := newInstance(refreshed, src, InstanceStatusPending, t2)
+	pending.evictAt = t2.Add(time.Second * 15)
+	require.Equal(t, t0, pending.FirstSeen())
+	require.Equal(t, t2, pending.StatusChangedAt())
+	require.True(t, t0.Equal(pending.LastSeenHealthy()))
+	require.Equal(t, t2.Add(time.Second*15), pending.EvictAt())
+
+	t3 := t2.Add(time.Second)
+	recovered := newInstance(pending, src, InstanceStatusAlive, t3)
+	require.Equal(t, t0, recovered.FirstSeen())
+	require.Equal(t, t3, recovered.StatusChangedAt())
+	require.True(t, recovered.EvictAt().IsZero())
+}
@@ -0,0 +1,221 @@
+package elector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/horockey/go-consul-instance-manager/clock"
+	"github.com/horockey/go-consul-instance-manager/internal/retrier"
+	"github.com/rs/zerolog"
+)
+
+// Elects single leader among processes, using consul session and KV lock.
+// Elector acquires lock for given key with its value
+// and emits name of current leader on every change.
+type Elector struct {
+	kv      *consul.KV
+	session *consul.Session
+	retrier *retrier.Retrier
+
+	key        string
+	value      string
+	sessionTTL time.Duration
+
+	out chan string
+
+	clock  clock.Clock
+	logger zerolog.Logger
+}
+
+func New(
+	cl *consul.Client,
+	retrier *retrier.Retrier,
+	key string,
+	value string,
+	sessionTTL time.Duration,
+	clk clock.Clock,
+	logger zerolog.Logger,
+) *Elector {
+	return &Elector{
+		kv:         cl.KV(),
+		session:    cl.Session(),
+		retrier:    retrier,
+		key:        key,
+		value:      value,
+		sessionTTL: sessionTTL,
+		out:        make(chan string, 10),
+		clock:      clk,
+		logger:     logger,
+	}
+}
+
+// Emits value of current leader.
+// Empty value means that there is no leader now.
+func (e *Elector) Out() chan string {
+	return e.out
+}
+
+func (e *Elector) Start(ctx context.Context) error {
+	leader := ""
+
+	for {
+		if err := e.runSession(ctx, &leader); err != nil && ctx.Err() == nil {
+			e.logger.Error().
+				Err(fmt.Errorf("running election session: %w", err)).
+				Send()
+		}
+
+		if leader != "" {
+			leader = ""
+			e.emit(ctx, leader)
+		}
+
+		timer := e.clock.NewTimer(e.sessionTTL / 2)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return fmt.Errorf("running context: %w", ctx.Err())
+		case <-timer.C():
+		}
+	}
+}
+
+func (e *Elector) runSession(ctx context.Context, leader *string) error {
+	var id string
+	err := e.retrier.Do(ctx, "session create", func() error {
+		var err error
+		id, _, err = e.session.CreateNoChecks(&consul.SessionEntry{
+			Name:     e.key,
+			TTL:      e.sessionTTL.String(),
+			Behavior: consul.SessionBehaviorRelease,
+		}, (&consul.WriteOptions{}).WithContext(ctx))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("creating session: %w", err)
+	}
+	defer e.destroySession(id)
+
+	sessCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	renewErrs := make(chan error, 1)
+	go func() {
+		defer cancel()
+		renewErrs <- e.renewSession(sessCtx, id)
+	}()
+
+	var waitIdx uint64
+	for {
+		if *leader == "" {
+			err := e.retrier.Do(sessCtx, "kv acquire", func() error {
+				_, _, err := e.kv.Acquire(&consul.KVPair{
+					Key:     e.key,
+					Value:   []byte(e.value),
+					Session: id,
+				}, (&consul.WriteOptions{}).WithContext(sessCtx))
+				return err
+			})
+			if err != nil {
+				return errors.Join(fmt.Errorf("acquiring leader key: %w", err), sessionErr(renewErrs))
+			}
+		}
+
+		var (
+			pair *consul.KVPair
+			meta *consul.QueryMeta
+		)
+		err := e.retrier.Do(sessCtx, "kv get", func() error {
+			var err error
+			pair, meta, err = e.kv.Get(e.key, (&consul.QueryOptions{
+				WaitIndex: waitIdx,
+				WaitTime:  e.sessionTTL,
+			}).WithContext(sessCtx))
+			return err
+		})
+		if err != nil {
+			return errors.Join(fmt.Errorf("getting leader key: %w", err), sessionErr(renewErrs))
+		}
+		waitIdx = meta.LastIndex
+
+		cur := ""
+		if pair != nil && pair.Session != "" {
+			cur = string(pair.Value)
+		}
+
+		if cur != *leader {
+			*leader = cur
+			e.emit(ctx, cur)
+		}
+	}
+}
+
+func (e *Elector) renewSession(ctx context.Context, id string) error {
+	ticker := e.clock.NewTicker(e.sessionTTL / 2)
+	defer ticker.Stop()
+
+	lastRenew := e.clock.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			var entry *consul.SessionEntry
+			err := e.retrier.Do(ctx, "session renew", func() error {
+				var err error
+				entry, _, err = e.session.Renew(id, (&consul.WriteOptions{}).WithContext(ctx))
+				return err
+			})
+			switch {
+			case err != nil:
+				if e.clock.Now().Sub(lastRenew) >= e.sessionTTL {
+					return fmt.Errorf("renewing session: %w", err)
+				}
+				e.logger.Warn().
+					Err(fmt.Errorf("renewing session: %w", err)).
+					Send()
+			case entry == nil:
+				return consul.ErrSessionExpired
+			default:
+				lastRenew = e.clock.Now()
+			}
+		}
+	}
+}
+
+func (e *Elector) destroySession(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.sessionTTL/2)
+	defer cancel()
+
+	err := e.retrier.Do(ctx, "session destroy", func() error {
+		_, err := e.session.Destroy(id, (&consul.WriteOptions{}).WithContext(ctx))
+		return err
+	})
+	if err != nil {
+		e.logger.Error().
+			Err(fmt.Errorf("destroying session: %w", err)).
+			Send()
+	}
+}
+
+func (e *Elector) emit(ctx context.Context, leader string) {
+	select {
+	case e.out <- leader:
+	case <-ctx.Done():
+	}
+}
+
+func sessionErr(renewErrs chan error) error {
+	select {
+	case err := <-renewErrs:
+		return err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,151 @@
+package healthchecker
+
+import (
+	"math"
+	"time"
+
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+)
+
+// Penalty, that is added to instance on every flap.
+const flapPenalty = 1.0
+
+// Penalty, below which it is considered fully decayed.
+const negligiblePenalty = 0.01
+
+type DampingConfig struct {
+	// Count of consecutive failing observations before instance is reported down.
+	Failures uint
+	// Count of consecutive up observations before down instance is reported up again.
+	Passes uint
+
+	// Half-life of flap penalty. Zero disables flap damping.
+	HalfLife time.Duration
+	// Instance with penalty above this value is suppressed (reported down).
+	SuppressPenalty float64
+	// Suppressed instance is released, when its penalty decays below this value.
+	ReusePenalty float64
+}
+
+type flapState struct {
+	last       model.Instance
+	rawUp      bool
+	reportedUp bool
+	suppressed bool
+
+	passes   uint
+	failures uint
+
+	penalty   float64
+	penaltyAt time.Time
+}
+
+// Smooths raw observations of instances health,
+// so flaky instances do not produce stream of changes.
+type damper struct {
+	cfg          DampingConfig
+	states       map[string]*flapState
+	bootstrapped bool
+}
+
+func newDamper(cfg DampingConfig) *damper {
+	if cfg.Failures == 0 {
+		cfg.Failures = 1
+	}
+	if cfg.Passes == 0 {
+		cfg.Passes = 1
+	}
+
+	return &damper{
+		cfg:    cfg,
+		states: map[string]*flapState{},
+	}
+}
+
+// Gets instances, that are up according to raw observation of the scan,
+// and returns instances, that must be reported up.
+func (d *damper) filter(rawAlives []model.Instance, now time.Time) []model.Instance {
+	seen := make(map[string]struct{}, len(rawAlives))
+	alives := make([]model.Instance, 0, len(rawAlives))
+
+	for _, ins := range rawAlives {
+		seen[ins.Name] = struct{}{}
+
+		st, found := d.states[ins.Name]
+		if !found {
+			// Instances of the first scan are trusted as is,
+			// later ones have to pass like recovering ones.
+			st = &flapState{reportedUp: !d.bootstrapped}
+			d.states[ins.Name] = st
+		}
+
+		d.observe(st, true, now)
+		st.last = ins
+
+		if st.reportedUp {
+			alives = append(alives, ins)
+		}
+	}
+
+	for name, st := range d.states {
+		if _, found := seen[name]; found {
+			continue
+		}
+
+		d.observe(st, false, now)
+
+		if st.reportedUp {
+			alives = append(alives, st.last)
+			continue
+		}
+
+		if !st.suppressed && st.penalty < negligiblePenalty {
+			delete(d.states, name)
+		}
+	}
+
+	d.bootstrapped = true
+
+	return alives
+}
+
+func (d *damper) observe(st *flapState, rawUp bool, now time.Time) {
+	d.decay(st, now)
+
+	if st.rawUp != rawUp && (st.passes > 0 || st.failures > 0) && d.cfg.HalfLife > 0 {
+		st.penalty += flapPenalty
+	}
+	st.rawUp = rawUp
+
+	if rawUp {
+		st.passes++
+		st.failures = 0
+	} else {
+		st.failures++
+		st.passes = 0
+	}
+
+	switch {
+	case d.cfg.HalfLife > 0 && st.penalty >= d.cfg.SuppressPenalty:
+		st.suppressed = true
+	case st.suppressed && st.penalty < d.cfg.ReusePenalty:
+		st.suppressed = false
+	}
+
+	switch {
+	case st.suppressed:
+		st.reportedUp = false
+	case st.reportedUp && !rawUp && st.failures >= d.cfg.Failures:
+		st.reportedUp = false
+	case !st.reportedUp && rawUp && st.passes >= d.cfg.Passes:
+		st.reportedUp = true
+	}
+}
+
+func (d *damper) decay(st *flapState, now time.Time) {
+	if st.penalty > 0 && d.cfg.HalfLife > 0 {
+		halfLives := float64(now.Sub(st.penaltyAt)) / float64(d.cfg.HalfLife)
+		st.penalty *= math.Pow(0.5, halfLives)
+	}
+	st.penaltyAt = now
+}
@@ -0,0 +1,105 @@
+package healthchecker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+var instance = model.Instance{
+	Name:    "node1",
+	Address: "localhost:8081",
+}
+
+func names(inses []model.Instance) []string {
+	res := make([]string, 0, len(inses))
+	for _, ins := range inses {
+		res = append(res, ins.Name)
+	}
+	return res
+}
+
+func TestDamper_Hysteresis(t *testing.T) {
+	d := newDamper(DampingConfig{Failures: 3, Passes: 2})
+	now := time.Now()
+
+	observations := []struct {
+		up       bool
+		expected bool
+	}{
+		{up: true, expected: true},
+		{up: false, expected: true},
+		{up: false, expected: true},
+		{up: true, expected: true},
+		{up: false, expected: true},
+		{up: false, expected: true},
+		{up: false, expected: false},
+		{up: true, expected: false},
+		{up: false, expected: false},
+		{up: true, expected: false},
+		{up: true, expected: true},
+	}
+
+	for idx, obs := range observations {
+		raw := []model.Instance{}
+		if obs.up {
+			raw = append(raw, instance)
+		}
+
+		alives := d.filter(raw, now.Add(time.Second*time.Duration(idx)))
+		require.Equal(t, obs.expected, len(alives) == 1, "observation %d", idx)
+	}
+}
+
+func TestDamper_NewInstanceAfterBootstrap(t *testing.T) {
+	d := newDamper(DampingConfig{Passes: 2})
+	now := time.Now()
+
+	require.Empty(t, d.filter([]model.Instance{}, now))
+	require.Empty(t, d.filter([]model.Instance{instance}, now.Add(time.Second)))
+	require.Equal(t, []string{instance.Name}, names(d.filter([]model.Instance{instance}, now.Add(time.Second*2))))
+}
+
+func TestDamper_FlapSuppression(t *testing.T) {
+	halfLife := time.Minute
+	d := newDamper(DampingConfig{
+		HalfLife:        halfLife,
+		SuppressPenalty: 3.5,
+		ReusePenalty:    1,
+	})
+	now := time.Now()
+
+	up := []model.Instance{instance}
+	down := []model.Instance{}
+
+	require.Len(t, d.filter(up, now), 1)
+
+	// Three flaps make penalty close to 3, which is still below suppress threshold.
+	now = now.Add(time.Second)
+	require.Len(t, d.filter(down, now), 0)
+	now = now.Add(time.Second)
+	require.Len(t, d.filter(up, now), 1)
+	now = now.Add(time.Second)
+	require.Len(t, d.filter(down, now), 0)
+
+	// Fourth flap exceeds suppress threshold, instance stays down while it is up.
+	now = now.Add(time.Second)
+	require.Len(t, d.filter(up, now), 0)
+	now = now.Add(halfLife)
+	require.Len(t, d.filter(up, now), 0)
+
+	// Penalty decays below reuse threshold after two more half-lives.
+	now = now.Add(halfLife * 2)
+	require.Len(t, d.filter(up, now), 1)
+}
+
+func TestDamper_ForgetsStableDownInstance(t *testing.T) {
+	d := newDamper(DampingConfig{})
+	now := time.Now()
+
+	require.Len(t, d.filter([]model.Instance{instance}, now), 1)
+	require.Len(t, d.filter([]model.Instance{}, now.Add(time.Second)), 0)
+	require.Empty(t, d.states)
+}
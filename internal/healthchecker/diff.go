@@ -0,0 +1,38 @@
+package healthchecker
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+)
+
+// Set of instances, keyed by name.
+type instanceSet map[string]model.Instance
+
+// Fills next with given alives and finds changes relatively to last.
+// Instances, that are new or changed, are upped,
+// instances of last, that are absent in alives, are downed.
+// Only the first of instances with the same name is taken into account.
+// Downed instances are sorted by name.
+func diff(last instanceSet, next instanceSet, alives []model.Instance) (upped []model.Instance, downed []model.Instance) {
+	for _, ins := range alives {
+		if _, dup := next[ins.Name]; dup {
+			continue
+		}
+		next[ins.Name] = ins
+
+		if prev, found := last[ins.Name]; !found || !prev.Equal(ins) {
+			upped = append(upped, ins)
+		}
+	}
+
+	for name, ins := range last {
+		if _, found := next[name]; !found {
+			downed = append(downed, ins)
+		}
+	}
+	slices.SortFunc(downed, func(a, b model.Instance) int { return strings.Compare(a.Name, b.Name) })
+
+	return upped, downed
+}
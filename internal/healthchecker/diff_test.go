@@ -0,0 +1,158 @@
+package healthchecker
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	node1 := model.Instance{Name: "node1", Address: "localhost:8081"}
+	node2 := model.Instance{Name: "node2", Address: "localhost:8082"}
+	node3 := model.Instance{Name: "node3", Address: "localhost:8083"}
+
+	withAddress := func(ins model.Instance, addr string) model.Instance {
+		ins.Address = addr
+		return ins
+	}
+	withPort := func(ins model.Instance, port int) model.Instance {
+		ins.ServicePort = port
+		return ins
+	}
+	withTags := func(ins model.Instance, tags ...string) model.Instance {
+		ins.Tags = tags
+		return ins
+	}
+	degraded := func(ins model.Instance) model.Instance {
+		ins.Degraded = true
+		return ins
+	}
+
+	testCases := []struct {
+		name   string
+		last   []model.Instance
+		alives []model.Instance
+		upped  []model.Instance
+		downed []model.Instance
+	}{
+		{
+			name:   "first scan",
+			alives: []model.Instance{node1, node2},
+			upped:  []model.Instance{node1, node2},
+		},
+		{
+			name:   "no changes",
+			last:   []model.Instance{node1, node2},
+			alives: []model.Instance{node2, node1},
+		},
+		{
+			name:   "downed are sorted",
+			last:   []model.Instance{node3, node1, node2},
+			alives: []model.Instance{node2},
+			downed: []model.Instance{node1, node3},
+		},
+		{
+			name:   "up and down",
+			last:   []model.Instance{node1, node2},
+			alives: []model.Instance{node2, node3},
+			upped:  []model.Instance{node3},
+			downed: []model.Instance{node1},
+		},
+		{
+			name:   "address change is not down",
+			last:   []model.Instance{node1},
+			alives: []model.Instance{withAddress(node1, "localhost:9091")},
+			upped:  []model.Instance{withAddress(node1, "localhost:9091")},
+		},
+		{
+			name:   "port change is not down",
+			last:   []model.Instance{withPort(node1, 8080)},
+			alives: []model.Instance{withPort(node1, 9090)},
+			upped:  []model.Instance{withPort(node1, 9090)},
+		},
+		{
+			name:   "tags change",
+			last:   []model.Instance{node1},
+			alives: []model.Instance{withTags(node1, "v2")},
+			upped:  []model.Instance{withTags(node1, "v2")},
+		},
+		{
+			name:   "degradation",
+			last:   []model.Instance{node1, node2},
+			alives: []model.Instance{degraded(node1), node2},
+			upped:  []model.Instance{degraded(node1)},
+		},
+		{
+			name:   "duplicates",
+			alives: []model.Instance{node1, withAddress(node1, "localhost:9091")},
+			upped:  []model.Instance{node1},
+		},
+		{
+			name:   "all down",
+			last:   []model.Instance{node1, node2},
+			downed: []model.Instance{node1, node2},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := instanceSet{}
+			upped, downed := diff(newInstanceSet(tc.last...), next, tc.alives)
+
+			require.Equal(t, tc.upped, upped)
+			require.Equal(t, tc.downed, downed)
+			require.Len(t, next, len(newInstanceSet(tc.alives...)))
+		})
+	}
+}
+
+func newInstanceSet(inses ...model.Instance) instanceSet {
+	set := make(instanceSet, len(inses))
+	for _, ins := range inses {
+		set[ins.Name] = ins
+	}
+	return set
+}
+
+func newInstances(n int) []model.Instance {
+	inses := make([]model.Instance, 0, n)
+	for idx := 0; idx < n; idx++ {
+		inses = append(inses, model.Instance{
+			Name:    "node" + strconv.Itoa(idx),
+			Address: "10.0.0.1:" + strconv.Itoa(idx),
+			Tags:    []string{"v1"},
+			Meta:    map[string]string{"zone": "a"},
+		})
+	}
+	return inses
+}
+
+func BenchmarkDiff_10k_Stable(b *testing.B) {
+	alives := newInstances(10_000)
+	last, next := newInstanceSet(alives...), instanceSet{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clear(next)
+		_, _ = diff(last, next, alives)
+		last, next = next, last
+	}
+}
+
+func BenchmarkDiff_10k_Churn(b *testing.B) {
+	all := newInstances(10_100)
+	// Every scan 1% of instances goes down and another 1% comes up.
+	scans := [][]model.Instance{all[:10_000], all[100:]}
+	last, next := newInstanceSet(scans[1]...), instanceSet{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clear(next)
+		_, _ = diff(last, next, scans[i%2])
+		last, next = next, last
+	}
+}
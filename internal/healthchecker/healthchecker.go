@@ -5,55 +5,125 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"slices"
+	"sync"
 	"time"
 
 	consul "github.com/hashicorp/consul/api"
+	"github.com/horockey/go-consul-instance-manager/clock"
 	"github.com/horockey/go-consul-instance-manager/internal/model"
+	"github.com/horockey/go-consul-instance-manager/internal/queue"
+	"github.com/horockey/go-consul-instance-manager/internal/retrier"
 	"github.com/rs/zerolog"
 )
 
+// Part of consul catalog API, used by health checker.
+// Implemented by *consul.Catalog.
+type Catalog interface {
+	Service(service string, tag string, q *consul.QueryOptions) ([]*consul.CatalogService, *consul.QueryMeta, error)
+}
+
 type HealthChecker struct {
-	cl          *consul.Client
+	catalog     Catalog
+	retrier     *retrier.Retrier
 	serviceName string
 
-	lastScanAlives []model.Instance
+	// Alives of the last scan and spare set, reused to build the next one.
+	lastScanAlives instanceSet
+	spareAlives    instanceSet
 	pollInterval   time.Duration
+	clock          clock.Clock
+	damper         *damper
+	panic          *panicDetector
+
+	statusMu sync.Mutex
+	status   SyncStatus
 
-	out chan model.InstanceChange
+	out    *queue.Queue[struct{}, model.ChangeSet]
+	panics chan bool
 
 	logger zerolog.Logger
 }
 
 func New(
-	cl *consul.Client,
+	catalog Catalog,
+	retrier *retrier.Retrier,
 	serviceName string,
 	pollInterval time.Duration,
-	outChanSize uint,
+	damping DampingConfig,
+	panicCfg PanicConfig,
+	clk clock.Clock,
 	logger zerolog.Logger,
 ) *HealthChecker {
 	return &HealthChecker{
-		cl:             cl,
-		lastScanAlives: []model.Instance{},
+		catalog:        catalog,
+		retrier:        retrier,
+		lastScanAlives: instanceSet{},
+		spareAlives:    instanceSet{},
 		serviceName:    serviceName,
 		pollInterval:   pollInterval,
-		out:            make(chan model.InstanceChange, outChanSize),
+		clock:          clk,
+		damper:         newDamper(damping),
+		panic:          &panicDetector{cfg: panicCfg},
+		out:            queue.New(1, func(model.ChangeSet) struct{} { return struct{}{} }, mergeChangeSets),
+		panics:         make(chan bool, 1),
 		logger:         logger,
 	}
 }
 
-func (hc *HealthChecker) Out() chan model.InstanceChange {
+// Gets queue of changes of successful scans.
+// Every scan is pushed as a single change set even if there are no changes, so it also signals the scan.
+// Change sets, that are not taken yet, are merged into one.
+func (hc *HealthChecker) Out() *queue.Queue[struct{}, model.ChangeSet] {
 	return hc.out
 }
 
+// Emits panic state, replacing the one, that is not received yet.
+func (hc *HealthChecker) emitPanic(panicking bool) {
+	select {
+	case <-hc.panics:
+	default:
+	}
+	hc.panics <- panicking
+}
+
+// Merges change sets of successive scans, keeping the latest change of every instance.
+func mergeChangeSets(pending model.ChangeSet, pushed model.ChangeSet) model.ChangeSet {
+	pushedNames := make(map[string]struct{}, len(pushed.Changes))
+	for _, ch := range pushed.Changes {
+		pushedNames[ch.Instance.Name] = struct{}{}
+	}
+
+	changes := make([]model.InstanceChange, 0, len(pending.Changes)+len(pushed.Changes))
+	for _, ch := range pending.Changes {
+		if _, found := pushedNames[ch.Instance.Name]; !found {
+			changes = append(changes, ch)
+		}
+	}
+
+	return model.ChangeSet{
+		Changes:   append(changes, pushed.Changes...),
+		ScannedAt: pushed.ScannedAt,
+	}
+}
+
+// Emits true, when consul responses become untrusted and topology must be frozen,
+// and false, when consul looks healthy again.
+// If previous emission was not received yet, it is replaced.
+// Change set of the scan, that ends panic, is sent to Out before the emission.
+func (hc *HealthChecker) Panics() chan bool {
+	return hc.panics
+}
+
 func (hc *HealthChecker) Start(ctx context.Context) error {
-	if err := hc.scan(); err != nil {
+	if err := hc.scan(ctx); err != nil {
 		hc.logger.Error().
 			Err(fmt.Errorf("scanning alive nodes: %w", err)).
 			Send()
 	}
 
-	ticker := time.NewTicker(hc.pollInterval)
+	ticker := hc.clock.NewTicker(hc.pollInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -61,8 +131,8 @@ func (hc *HealthChecker) Start(ctx context.Context) error {
 				return nil
 			}
 			return fmt.Errorf("running context: %w", ctx.Err())
-		case <-ticker.C:
-			if err := hc.scan(); err != nil {
+		case <-ticker.C():
+			if err := hc.scan(ctx); err != nil {
 				hc.logger.Error().
 					Err(fmt.Errorf("scanning alive nodes: %w", err)).
 					Send()
@@ -71,48 +141,111 @@ func (hc *HealthChecker) Start(ctx context.Context) error {
 	}
 }
 
-func (hc *HealthChecker) scan() error {
-	entries, _, err := hc.cl.Catalog().Service(hc.serviceName, "", nil)
-	if err != nil && !errors.Is(err, io.EOF) {
-		return fmt.Errorf("getting service entries: %w", err)
+func (hc *HealthChecker) scan(ctx context.Context) error {
+	var (
+		entries []*consul.CatalogService
+		meta    *consul.QueryMeta
+	)
+	err := hc.retrier.Do(ctx, "catalog service", func() error {
+		var err error
+		entries, meta, err = hc.catalog.Service(hc.serviceName, "", (&consul.QueryOptions{}).WithContext(ctx))
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		err = fmt.Errorf("getting service entries: %w", err)
+		hc.syncFailed(err)
+		if hc.panic.fail() {
+			hc.emitPanic(true)
+		}
+		return err
+	}
+	if hc.panic.cfg.Enabled && meta != nil && !meta.KnownLeader {
+		err = errors.New("consul has no known leader")
+		hc.syncFailed(err)
+		if hc.panic.fail() {
+			hc.emitPanic(true)
+		}
+		return err
+	}
+	scannedAt := hc.clock.Now()
+
+	var index uint64
+	if meta != nil {
+		index = meta.LastIndex
 	}
 
 	alives := make([]model.Instance, 0, len(entries))
 
 	for _, entry := range entries {
-		if entry.Checks.AggregatedStatus() != consul.HealthPassing {
+		status := entry.Checks.AggregatedStatus()
+		if status != consul.HealthPassing && status != consul.HealthWarning {
 			continue
 		}
 
 		alives = append(alives, model.Instance{
-			Name:    entry.Node,
-			Address: entry.Address,
+			Name:           entry.Node,
+			Address:        entry.Address,
+			ServiceAddress: entry.ServiceAddress,
+			ServicePort:    entry.ServicePort,
+			Tags:           entry.ServiceTags,
+			Meta:           entry.ServiceMeta,
+			Degraded:       status == consul.HealthWarning,
 		})
 	}
 
-	upped := slices.DeleteFunc(alives, func(el model.Instance) bool {
-		return slices.Contains(hc.lastScanAlives, el)
-	})
+	apply, panicChanged := hc.panic.check(hc.lastScanAlives, alives, scannedAt)
+	hc.synced(index, scannedAt, apply)
+	if !apply {
+		if panicChanged {
+			hc.logger.Warn().
+				Int("last_alives", len(hc.lastScanAlives)).
+				Int("alives", len(alives)).
+				Msg("mass disappearance of instances, freezing topology")
+			hc.emitPanic(true)
+		}
+		return nil
+	}
 
-	downed := slices.DeleteFunc(hc.lastScanAlives, func(el model.Instance) bool {
-		return slices.Contains(alives, el)
-	})
+	alives = hc.damper.filter(alives, scannedAt)
 
+	// Instance with changed health, address, tags or meta is reported as upped again to refresh it.
+	// Instance with changed address or port is not reported as downed, as it is the same node.
+	next := hc.spareAlives
+	clear(next)
+	upped, downed := diff(hc.lastScanAlives, next, alives)
+
+	changes := make([]model.InstanceChange, 0, len(upped)+len(downed))
 	for _, ins := range upped {
-		hc.out <- model.InstanceChange{
+		changes = append(changes, model.InstanceChange{
 			Instance: ins,
 			IsDown:   false,
-		}
+		})
 	}
 
 	for _, ins := range downed {
-		hc.out <- model.InstanceChange{
+		changes = append(changes, model.InstanceChange{
 			Instance: ins,
 			IsDown:   true,
-		}
+		})
 	}
 
-	hc.lastScanAlives = alives
+	hc.out.Push(model.ChangeSet{
+		Changes:   changes,
+		ScannedAt: scannedAt,
+	})
+
+	hc.spareAlives = hc.lastScanAlives
+	hc.lastScanAlives = next
+
+	if panicChanged {
+		hc.emitPanic(false)
+	}
 
 	return nil
 }
@@ -0,0 +1,227 @@
+package healthchecker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/horockey/go-consul-instance-manager/clock"
+	"github.com/horockey/go-consul-instance-manager/clock/clocktest"
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+	"github.com/horockey/go-consul-instance-manager/internal/retrier"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+// In-memory catalog of a single service.
+type fakeCatalog struct {
+	mu      sync.Mutex
+	entries []*consul.CatalogService
+	index   uint64
+}
+
+func (fc *fakeCatalog) Service(string, string, *consul.QueryOptions) ([]*consul.CatalogService, *consul.QueryMeta, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.entries, &consul.QueryMeta{LastIndex: fc.index, KnownLeader: true}, nil
+}
+
+func (fc *fakeCatalog) set(inses []model.Instance, statuses map[string]string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.index++
+	fc.entries = make([]*consul.CatalogService, 0, len(inses))
+	for _, ins := range inses {
+		status := consul.HealthPassing
+		if st, found := statuses[ins.Name]; found {
+			status = st
+		}
+
+		fc.entries = append(fc.entries, &consul.CatalogService{
+			Node:        ins.Name,
+			Address:     ins.Address,
+			ServiceTags: ins.Tags,
+			ServiceMeta: ins.Meta,
+			Checks:      consul.HealthChecks{{Node: ins.Name, Status: status}},
+		})
+	}
+}
+
+func newTestHealthChecker(catalog Catalog, clk clock.Clock) *HealthChecker {
+	return New(
+		catalog,
+		retrier.New(retrier.Policy{}, clk, zerolog.Nop()),
+		"app",
+		0,
+		DampingConfig{},
+		PanicConfig{},
+		clk,
+		zerolog.Nop(),
+	)
+}
+
+// Scans catalog and collects changes, sent by the scan.
+func scanChanges(t testing.TB, hc *HealthChecker) (upped []string, downed []string) {
+	require.NoError(t, hc.scan(context.TODO()))
+
+	<-hc.Out().Notify()
+	cs, ok := hc.Out().Pop()
+	require.True(t, ok)
+	for _, ch := range cs.Changes {
+		if ch.IsDown {
+			downed = append(downed, ch.Instance.Name)
+		} else {
+			upped = append(upped, ch.Instance.Name)
+		}
+	}
+
+	return upped, downed
+}
+
+func TestScan_FakeCatalog(t *testing.T) {
+	catalog := &fakeCatalog{}
+	hc := newTestHealthChecker(catalog, clock.New())
+
+	node1 := model.Instance{Name: "node1", Address: "localhost:8081"}
+	node2 := model.Instance{Name: "node2", Address: "localhost:8082"}
+	node3 := model.Instance{Name: "node3", Address: "localhost:8083"}
+
+	steps := []struct {
+		name     string
+		inses    []model.Instance
+		statuses map[string]string
+		upped    []string
+		downed   []string
+	}{
+		{
+			name:  "initial",
+			inses: []model.Instance{node1, node2},
+			upped: []string{"node1", "node2"},
+		},
+		{
+			name:  "unchanged",
+			inses: []model.Instance{node1, node2},
+		},
+		{
+			name:     "critical check",
+			inses:    []model.Instance{node1, node2},
+			statuses: map[string]string{"node2": consul.HealthCritical},
+			downed:   []string{"node2"},
+		},
+		{
+			name:     "warning check",
+			inses:    []model.Instance{node1, node2},
+			statuses: map[string]string{"node1": consul.HealthWarning},
+			upped:    []string{"node1", "node2"},
+		},
+		{
+			name:   "deregistration and registration",
+			inses:  []model.Instance{node2, node3},
+			upped:  []string{"node3"},
+			downed: []string{"node1"},
+		},
+		{
+			name:  "address change",
+			inses: []model.Instance{{Name: "node2", Address: "localhost:9092"}, node3},
+			upped: []string{"node2"},
+		},
+		{
+			name:   "empty catalog",
+			downed: []string{"node2", "node3"},
+		},
+	}
+
+	for _, step := range steps {
+		catalog.set(step.inses, step.statuses)
+
+		upped, downed := scanChanges(t, hc)
+		require.Equal(t, step.upped, upped, step.name)
+		require.Equal(t, step.downed, downed, step.name)
+		require.Equal(t, catalog.index, hc.SyncStatus().Index, step.name)
+	}
+}
+
+func BenchmarkScan_10k(b *testing.B) {
+	all := newInstances(10_100)
+	scans := [][]model.Instance{all[:10_000], all[100:]}
+
+	catalogs := []*fakeCatalog{{}, {}}
+	for idx, inses := range scans {
+		catalogs[idx].set(inses, nil)
+	}
+
+	hc := newTestHealthChecker(catalogs[1], clock.New())
+	_, _ = scanChanges(b, hc)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hc.catalog = catalogs[i%2]
+		_, _ = scanChanges(b, hc)
+	}
+}
+
+func TestScan_CoalescesChangeSets(t *testing.T) {
+	catalog := &fakeCatalog{}
+	hc := newTestHealthChecker(catalog, clock.New())
+
+	node1 := model.Instance{Name: "node1", Address: "localhost:8081"}
+	node2 := model.Instance{Name: "node2", Address: "localhost:8082"}
+
+	// Consumer stalls for three scans.
+	catalog.set([]model.Instance{node1, node2}, nil)
+	require.NoError(t, hc.scan(context.TODO()))
+	catalog.set([]model.Instance{node1}, nil)
+	require.NoError(t, hc.scan(context.TODO()))
+	catalog.set([]model.Instance{node1}, map[string]string{"node1": consul.HealthWarning})
+	require.NoError(t, hc.scan(context.TODO()))
+
+	require.Equal(t, 1, hc.Out().Stats().Depth)
+	require.Equal(t, uint64(2), hc.Out().Stats().Coalesced)
+
+	cs, ok := hc.Out().Pop()
+	require.True(t, ok)
+	require.Equal(t, []model.InstanceChange{
+		{Instance: node2, IsDown: true},
+		{Instance: model.Instance{Name: "node1", Address: "localhost:8081", Degraded: true}},
+	}, cs.Changes)
+}
+
+func TestStart_PollsOnTick(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clk := clocktest.New(start)
+
+	catalog := &fakeCatalog{}
+	hc := newTestHealthChecker(catalog, clk)
+	hc.pollInterval = time.Minute
+
+	node1 := model.Instance{Name: "node1", Address: "localhost:8081"}
+	catalog.set([]model.Instance{node1}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = hc.Start(ctx) }()
+
+	// Ticker is created after the initial scan.
+	clk.BlockUntil(1)
+	<-hc.Out().Notify()
+	cs, ok := hc.Out().Pop()
+	require.True(t, ok)
+	require.Equal(t, start, cs.ScannedAt)
+	require.Equal(t, []model.InstanceChange{{Instance: node1}}, cs.Changes)
+
+	catalog.set(nil, nil)
+	clk.Advance(time.Minute - time.Second)
+	require.Zero(t, hc.Out().Stats().Depth)
+
+	clk.Advance(time.Second)
+	<-hc.Out().Notify()
+	cs, ok = hc.Out().Pop()
+	require.True(t, ok)
+	require.Equal(t, start.Add(time.Minute), cs.ScannedAt)
+	require.Equal(t, []model.InstanceChange{{Instance: node1, IsDown: true}}, cs.Changes)
+}
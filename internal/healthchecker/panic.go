@@ -0,0 +1,81 @@
+package healthchecker
+
+import (
+	"time"
+
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+)
+
+type PanicConfig struct {
+	// Enables panic mode.
+	Enabled bool
+	// Fraction of last known alive instances, disappearance of which in one scan triggers panic.
+	Threshold float64
+	// Duration, for which consul must stay healthy, before mass disappearance is accepted as real.
+	Confirm time.Duration
+}
+
+// Tracks whether consul responses can be trusted.
+type panicDetector struct {
+	cfg PanicConfig
+
+	panicking    bool
+	healthySince time.Time
+}
+
+// Registers failed request to consul.
+// Returns true, if panic is entered by this call.
+func (pd *panicDetector) fail() bool {
+	pd.healthySince = time.Time{}
+	if !pd.cfg.Enabled || pd.panicking {
+		return false
+	}
+
+	pd.panicking = true
+	return true
+}
+
+// Registers successful scan of consul, that found given raw alive instances.
+// Returns whether the scan must be applied and whether panic state was changed by this call.
+func (pd *panicDetector) check(last instanceSet, rawAlives []model.Instance, now time.Time) (apply bool, changed bool) {
+	if !pd.cfg.Enabled {
+		return true, false
+	}
+
+	if pd.healthySince.IsZero() {
+		pd.healthySince = now
+	}
+
+	massive := disappearedFraction(last, rawAlives) > pd.cfg.Threshold
+
+	if !pd.panicking {
+		if massive {
+			pd.panicking = true
+			pd.healthySince = now
+			return false, true
+		}
+		return true, false
+	}
+
+	if massive && now.Sub(pd.healthySince) < pd.cfg.Confirm {
+		return false, false
+	}
+
+	pd.panicking = false
+	return true, true
+}
+
+func disappearedFraction(last instanceSet, rawAlives []model.Instance) float64 {
+	if len(last) == 0 {
+		return 0
+	}
+
+	present := make(map[string]struct{}, len(rawAlives))
+	for _, ins := range rawAlives {
+		if _, found := last[ins.Name]; found {
+			present[ins.Name] = struct{}{}
+		}
+	}
+
+	return float64(len(last)-len(present)) / float64(len(last))
+}
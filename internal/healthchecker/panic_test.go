@@ -0,0 +1,66 @@
+package healthchecker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPanicDetector(t *testing.T) {
+	all := []model.Instance{
+		{Name: "node1", Address: "localhost:8081"},
+		{Name: "node2", Address: "localhost:8082"},
+		{Name: "node3", Address: "localhost:8083"},
+		{Name: "node4", Address: "localhost:8084"},
+	}
+	last := instanceSet{}
+	for _, ins := range all {
+		last[ins.Name] = ins
+	}
+	now := time.Now()
+
+	pd := &panicDetector{cfg: PanicConfig{
+		Enabled:   true,
+		Threshold: 0.5,
+		Confirm:   time.Minute,
+	}}
+
+	// Disappearance of a half is tolerated.
+	apply, changed := pd.check(last, all[:2], now)
+	require.True(t, apply)
+	require.False(t, changed)
+
+	// Mass disappearance starts panic.
+	apply, changed = pd.check(last, all[:1], now)
+	require.False(t, apply)
+	require.True(t, changed)
+
+	apply, changed = pd.check(last, all[:1], now.Add(time.Second*30))
+	require.False(t, apply)
+	require.False(t, changed)
+
+	// Consul is healthy long enough, disappearance is accepted.
+	apply, changed = pd.check(last, all[:1], now.Add(time.Minute))
+	require.True(t, apply)
+	require.True(t, changed)
+
+	// Error starts panic, which is ended by the first proper scan.
+	require.True(t, pd.fail())
+	require.False(t, pd.fail())
+
+	apply, changed = pd.check(last, all, now.Add(time.Minute*2))
+	require.True(t, apply)
+	require.True(t, changed)
+}
+
+func TestPanicDetector_Disabled(t *testing.T) {
+	pd := &panicDetector{}
+
+	require.False(t, pd.fail())
+
+	apply, changed := pd.check(instanceSet{"node1": {Name: "node1"}}, []model.Instance{}, time.Now())
+	require.True(t, apply)
+	require.False(t, changed)
+}
@@ -0,0 +1,41 @@
+package healthchecker
+
+import (
+	"time"
+)
+
+type SyncStatus struct {
+	LastSync            time.Time
+	LastError           error
+	ConsecutiveFailures uint
+	Index               uint64
+}
+
+// Get status of synchronization with consul.
+func (hc *HealthChecker) SyncStatus() SyncStatus {
+	hc.statusMu.Lock()
+	defer hc.statusMu.Unlock()
+
+	return hc.status
+}
+
+func (hc *HealthChecker) syncFailed(err error) {
+	hc.statusMu.Lock()
+	defer hc.statusMu.Unlock()
+
+	hc.status.LastError = err
+	hc.status.ConsecutiveFailures++
+}
+
+// Registers response of consul with given index.
+// applied is false, if topology was not updated by the response.
+func (hc *HealthChecker) synced(index uint64, at time.Time, applied bool) {
+	hc.statusMu.Lock()
+	defer hc.statusMu.Unlock()
+
+	hc.status.ConsecutiveFailures = 0
+	hc.status.Index = index
+	if applied {
+		hc.status.LastSync = at
+	}
+}
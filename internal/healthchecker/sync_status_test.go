@@ -0,0 +1,35 @@
+package healthchecker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncStatus(t *testing.T) {
+	hc := &HealthChecker{}
+	now := time.Now()
+	errScan := errors.New("scan error")
+
+	hc.synced(10, now, true)
+	hc.syncFailed(errScan)
+	hc.syncFailed(errScan)
+
+	require.Equal(t, SyncStatus{
+		LastSync:            now,
+		LastError:           errScan,
+		ConsecutiveFailures: 2,
+		Index:               10,
+	}, hc.SyncStatus())
+
+	// Frozen topology is not synced, but failures are reset.
+	hc.synced(11, now.Add(time.Second), false)
+
+	require.Equal(t, SyncStatus{
+		LastSync:  now,
+		LastError: errScan,
+		Index:     11,
+	}, hc.SyncStatus())
+}
@@ -1,6 +1,41 @@
 package model
 
+import (
+	"maps"
+	"slices"
+)
+
 type Instance struct {
 	Name    string
 	Address string
+	// Address and port of the service itself. Empty address means node address.
+	ServiceAddress string
+	ServicePort    int
+	Tags           []string
+	Meta           map[string]string
+
+	// Aggregated health status of the instance is warning.
+	Degraded bool
+}
+
+// Reports whether ins and other are the same node.
+// Node is identified by name, so it keeps identity on address change.
+func (ins Instance) SameNode(other Instance) bool {
+	return ins.Name == other.Name
+}
+
+// Reports whether ins and other are reachable at the same address and port.
+func (ins Instance) SameEndpoint(other Instance) bool {
+	return ins.Address == other.Address &&
+		ins.ServiceAddress == other.ServiceAddress &&
+		ins.ServicePort == other.ServicePort
+}
+
+// Reports whether ins and other are fully equal.
+func (ins Instance) Equal(other Instance) bool {
+	return ins.SameNode(other) &&
+		ins.SameEndpoint(other) &&
+		ins.Degraded == other.Degraded &&
+		slices.Equal(ins.Tags, other.Tags) &&
+		maps.Equal(ins.Meta, other.Meta)
 }
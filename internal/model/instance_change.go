@@ -1,6 +1,19 @@
 package model
 
+import (
+	"time"
+)
+
 type InstanceChange struct {
 	Instance Instance
 	IsDown   bool
+
+	// Generation of instance state, change was scheduled for.
+	Generation uint64
+}
+
+// Changes, found by a single scan of consul.
+type ChangeSet struct {
+	Changes   []InstanceChange
+	ScannedAt time.Time
 }
@@ -1,93 +1,211 @@
 package pending_instances_holder
 
 import (
+	"container/heap"
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/horockey/go-consul-instance-manager/clock"
 	"github.com/horockey/go-consul-instance-manager/internal/model"
-	"github.com/horockey/go-scheduler"
+	"github.com/horockey/go-consul-instance-manager/internal/queue"
 )
 
+// Holds instances for given period and emits them after it.
+// Timers are kept in a heap, so adding and removing of instance take O(log n).
 type PendingInstancesHolder struct {
 	holdPeriod time.Duration
-	sched      *scheduler.Scheduler[model.InstanceChange]
+	clock      clock.Clock
 
-	out chan model.InstanceChange
+	mu     sync.Mutex
+	timers timerHeap
+	byName map[string]*timer
+	wake   chan struct{}
+
+	out *queue.Queue[string, model.InstanceChange]
 }
 
-func New(holdPeriod time.Duration) (*PendingInstancesHolder, error) {
-	sched, err := scheduler.NewScheduler[model.InstanceChange]()
-	if err != nil {
-		return nil, fmt.Errorf("creating internal scheduler: %w", err)
+// Creates holder.
+// Its out queue is unbounded, since dropped expiration would never be emitted again.
+// Depth of the queue is bounded by count of held instances anyway.
+func New(holdPeriod time.Duration, clk clock.Clock) (*PendingInstancesHolder, error) {
+	if clk == nil {
+		return nil, errors.New("got nil clock")
 	}
 
 	return &PendingInstancesHolder{
 		holdPeriod: holdPeriod,
-		sched:      sched,
-		out:        make(chan model.InstanceChange, 100),
+		clock:      clk,
+		timers:     timerHeap{},
+		byName:     map[string]*timer{},
+		wake:       make(chan struct{}, 1),
+		out: queue.New[string, model.InstanceChange](
+			0,
+			func(ch model.InstanceChange) string { return ch.Instance.Name },
+			nil,
+		),
 	}, nil
 }
 
 func (pih *PendingInstancesHolder) Start(ctx context.Context) error {
-	errs := make(chan error)
+	for {
+		t := pih.emitExpired()
 
-	go func() {
-		defer close(errs)
-		if err := pih.sched.Start(ctx); err != nil {
-			errs <- err
+		var fired <-chan time.Time
+		if t != nil {
+			fired = t.C()
 		}
-	}()
-
-	var resErr error
-	func() {
-		for {
-			select {
-			case ev := <-pih.sched.EmitChan():
-				pih.out <- ev.Payload
-			case err := <-errs:
-				resErr = fmt.Errorf("running internal scheduler: %w", err)
-				return
-			case <-ctx.Done():
-				resErr = ctx.Err()
-				return
+
+		select {
+		case <-ctx.Done():
+			if t != nil {
+				t.Stop()
+			}
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return fmt.Errorf("running context: %w", ctx.Err())
+		case <-fired:
+		case <-pih.wake:
+			if t != nil {
+				t.Stop()
 			}
 		}
-	}()
+	}
+}
+
+// Emits all expired instances.
+// Returns timer, that fires at the next expiration, if there is any.
+// Timer is created under the lock, so its wait is measured from the same now.
+func (pih *PendingInstancesHolder) emitExpired() clock.Timer {
+	pih.mu.Lock()
+	defer pih.mu.Unlock()
+
+	now := pih.clock.Now()
+	for len(pih.timers) > 0 {
+		next := pih.timers[0]
+		if next.at.After(now) {
+			return pih.clock.NewTimer(next.at.Sub(now))
+		}
+
+		heap.Pop(&pih.timers)
+		delete(pih.byName, next.change.Instance.Name)
 
-	if !errors.Is(resErr, context.Canceled) {
-		return fmt.Errorf("running context: %w", resErr)
+		// Unbounded queue never drops.
+		pih.out.Push(next.change)
 	}
 
 	return nil
 }
 
-func (pih *PendingInstancesHolder) Out() chan model.InstanceChange {
+// Gets queue of emitted changes.
+// Changes of the same instance, that are not taken yet, are coalesced into the latest one.
+func (pih *PendingInstancesHolder) Out() *queue.Queue[string, model.InstanceChange] {
 	return pih.out
 }
 
-func (pih *PendingInstancesHolder) Add(ins model.Instance) error {
-	_, err := pih.sched.Schedule(
-		model.InstanceChange{
-			Instance: ins,
-			IsDown:   true,
-		},
-		scheduler.After[model.InstanceChange](pih.holdPeriod),
-		scheduler.Tag[model.InstanceChange](ins.Name),
-	)
-	if err != nil {
-		return fmt.Errorf("scheduling downed node: %w", err)
+// Schedules emission of given instance after hold period.
+// gen is passed to emitted change as is.
+func (pih *PendingInstancesHolder) Add(ins model.Instance, gen uint64) error {
+	return pih.AddFor(ins, gen, pih.holdPeriod)
+}
+
+// Schedules emission of given instance after given hold period
+// instead of the default one.
+// Previously scheduled emission of the instance is replaced.
+// gen is passed to emitted change as is.
+func (pih *PendingInstancesHolder) AddFor(ins model.Instance, gen uint64, holdPeriod time.Duration) error {
+	if holdPeriod < 0 {
+		return fmt.Errorf("hold period must not be negative, got: %d", holdPeriod)
+	}
+
+	change := model.InstanceChange{
+		Instance:   ins,
+		IsDown:     true,
+		Generation: gen,
+	}
+
+	pih.mu.Lock()
+	at := pih.clock.Now().Add(holdPeriod)
+	if t, found := pih.byName[ins.Name]; found {
+		t.change = change
+		t.at = at
+		heap.Fix(&pih.timers, t.index)
+	} else {
+		t = &timer{change: change, at: at}
+		heap.Push(&pih.timers, t)
+		pih.byName[ins.Name] = t
+	}
+	isNext := pih.timers[0].change.Instance.Name == ins.Name
+	pih.mu.Unlock()
+
+	if isNext {
+		pih.notify()
 	}
 
 	return nil
 }
 
+// Cancels scheduled emission of given instance.
+// It is no-op, if instance is not scheduled.
 func (pih *PendingInstancesHolder) Remove(ins model.Instance) error {
-	err := pih.sched.UnscheduleByTag(ins.Name)
-	if err != nil {
-		return fmt.Errorf("unscheduling downed node: %w", err)
+	pih.mu.Lock()
+	defer pih.mu.Unlock()
+
+	t, found := pih.byName[ins.Name]
+	if !found {
+		return nil
 	}
 
+	heap.Remove(&pih.timers, t.index)
+	delete(pih.byName, ins.Name)
+
 	return nil
 }
+
+// Wakes up running holder to reconsider the next expiration.
+func (pih *PendingInstancesHolder) notify() {
+	select {
+	case pih.wake <- struct{}{}:
+	default:
+	}
+}
+
+type timer struct {
+	change model.InstanceChange
+	at     time.Time
+	index  int
+}
+
+// Min-heap of timers by expiration time.
+type timerHeap []*timer
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].at.Before(h[j].at)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	t := x.(*timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return t
+}
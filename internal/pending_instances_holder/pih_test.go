@@ -2,64 +2,157 @@ package pending_instances_holder_test
 
 import (
 	"context"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/horockey/go-consul-instance-manager/clock/clocktest"
 	"github.com/horockey/go-consul-instance-manager/internal/model"
 	"github.com/horockey/go-consul-instance-manager/internal/pending_instances_holder"
 	"github.com/stretchr/testify/require"
 )
 
-var instance = model.Instance{
-	Name:    "node1",
-	Address: "localhost:8081",
-}
+var (
+	instance = model.Instance{
+		Name:    "node1",
+		Address: "localhost:8081",
+	}
+	// Instance, held for shorter period, shows what has been emitted by the moment of its emission.
+	marker = model.Instance{
+		Name:    "marker",
+		Address: "localhost:8082",
+	}
+	start = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
 
-func TestAdd(t *testing.T) {
-	pihDur := time.Second
-	pih, err := pending_instances_holder.New(pihDur)
+func newStartedHolder(t *testing.T, holdPeriod time.Duration) (*pending_instances_holder.PendingInstancesHolder, *clocktest.Clock) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	clk := clocktest.New(start)
+	pih, err := pending_instances_holder.New(holdPeriod, clk)
 	require.NoError(t, err)
 
 	go func() {
-		err := pih.Start(context.TODO())
+		err := pih.Start(ctx)
 		require.NoError(t, err)
 	}()
-	time.Sleep(time.Millisecond * 100)
 
-	ts := time.Now()
-	err = pih.Add(instance)
+	return pih, clk
+}
+
+// Waits for the next emitted change.
+func next(t *testing.T, pih *pending_instances_holder.PendingInstancesHolder) model.InstanceChange {
+	<-pih.Out().Notify()
+	ev, ok := pih.Out().Pop()
+	require.True(t, ok)
+	return ev
+}
+
+func TestAdd(t *testing.T) {
+	pihDur := time.Second
+	pih, clk := newStartedHolder(t, pihDur)
+
+	err := pih.Add(instance, 1)
+	require.NoError(t, err)
+	err = pih.AddFor(marker, 0, pihDur-time.Millisecond)
 	require.NoError(t, err)
 
-	ev := <-pih.Out()
+	clk.Advance(pihDur - time.Millisecond)
+	require.Equal(t, marker, next(t, pih).Instance)
+	require.Zero(t, pih.Out().Stats().Depth)
+
+	clk.Advance(time.Millisecond)
+	ev := next(t, pih)
 	require.Equal(t, instance, ev.Instance)
 	require.True(t, ev.IsDown)
-	require.WithinDuration(t, ts.Add(pihDur), time.Now(), time.Millisecond*50)
+	require.Equal(t, uint64(1), ev.Generation)
+}
+
+func TestAddFor(t *testing.T) {
+	pih, clk := newStartedHolder(t, time.Hour)
+
+	holdDur := time.Millisecond * 500
+	err := pih.AddFor(instance, 1, holdDur)
+	require.NoError(t, err)
+
+	clk.Advance(holdDur)
+	ev := next(t, pih)
+	require.Equal(t, instance, ev.Instance)
 }
 
 func TestRemove(t *testing.T) {
 	pihDur := time.Second
-	pih, err := pending_instances_holder.New(pihDur)
+	pih, clk := newStartedHolder(t, pihDur)
+
+	err := pih.Add(instance, 1)
 	require.NoError(t, err)
 
-	go func() {
-		err := pih.Start(context.TODO())
-		require.NoError(t, err)
-	}()
-	time.Sleep(time.Millisecond * 100)
+	err = pih.Remove(instance)
+	require.NoError(t, err)
 
-	err = pih.Add(instance)
+	err = pih.AddFor(marker, 0, pihDur*2)
 	require.NoError(t, err)
 
-	err = pih.Remove(instance)
+	clk.Advance(pihDur * 2)
+	require.Equal(t, marker, next(t, pih).Instance)
+	require.Zero(t, pih.Out().Stats().Depth)
+}
+
+func TestAddFor_Reschedule(t *testing.T) {
+	pih, clk := newStartedHolder(t, time.Hour)
+
+	err := pih.AddFor(instance, 1, time.Millisecond*200)
+	require.NoError(t, err)
+	err = pih.AddFor(instance, 2, time.Millisecond*500)
 	require.NoError(t, err)
+	err = pih.AddFor(marker, 0, time.Millisecond*300)
+	require.NoError(t, err)
+
+	clk.Advance(time.Millisecond * 300)
+	require.Equal(t, marker, next(t, pih).Instance)
+	require.Zero(t, pih.Out().Stats().Depth)
 
-	timer := time.NewTimer(time.Millisecond * 1500)
-	defer timer.Stop()
+	clk.Advance(time.Millisecond * 200)
+	ev := next(t, pih)
+	require.Equal(t, uint64(2), ev.Generation)
+	require.Zero(t, pih.Out().Stats().Depth)
+}
 
-	select {
-	case ev := <-pih.Out():
-		t.Fatalf("unexpected emission of event: %+v", ev)
-	case <-timer.C:
-		return
+func TestAddFor_Order(t *testing.T) {
+	pih, clk := newStartedHolder(t, time.Hour)
+
+	count := 1000
+	for idx := count - 1; idx >= 0; idx-- {
+		ins := model.Instance{Name: "node" + strconv.Itoa(idx)}
+		err := pih.AddFor(ins, uint64(idx), time.Millisecond*100+time.Microsecond*time.Duration(idx*100))
+		require.NoError(t, err)
+	}
+
+	clk.Advance(time.Second)
+	for idx := 0; idx < count; {
+		<-pih.Out().Notify()
+		for ev, ok := pih.Out().Pop(); ok; ev, ok = pih.Out().Pop() {
+			require.Equal(t, uint64(idx), ev.Generation)
+			idx++
+		}
 	}
 }
+
+func TestExpirationsAreNotDropped(t *testing.T) {
+	pihDur := time.Second
+	pih, clk := newStartedHolder(t, pihDur)
+
+	// More than any consumer is expected to leave in the queue.
+	const count = 20000
+	for i := 0; i < count; i++ {
+		err := pih.Add(model.Instance{Name: "node" + strconv.Itoa(i)}, 0)
+		require.NoError(t, err)
+	}
+
+	clk.Advance(pihDur)
+	require.Eventually(t, func() bool {
+		return pih.Out().Stats().Depth == count
+	}, time.Second, time.Millisecond*10)
+	require.Zero(t, pih.Out().Stats().Dropped)
+}
@@ -0,0 +1,104 @@
+// Comparison benchmark of pending instances holder with go-scheduler, it replaced.
+// Kept in separate module, so go-scheduler is not required by the main one.
+// Run with: go test -run - -bench . (from this directory).
+package schedulerbench_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/horockey/go-consul-instance-manager/clock"
+	"github.com/horockey/go-consul-instance-manager/internal/model"
+	"github.com/horockey/go-consul-instance-manager/internal/pending_instances_holder"
+	"github.com/horockey/go-scheduler"
+	"github.com/stretchr/testify/require"
+)
+
+// Interface of holder for benchmarks.
+type holder interface {
+	Add(ins model.Instance, gen uint64) error
+	Remove(ins model.Instance) error
+}
+
+// Previous implementation of holder, based on go-scheduler, kept for comparison.
+type schedulerHolder struct {
+	sched *scheduler.Scheduler[model.InstanceChange]
+}
+
+func (sh *schedulerHolder) Add(ins model.Instance, gen uint64) error {
+	_, err := sh.sched.Schedule(
+		model.InstanceChange{Instance: ins, IsDown: true, Generation: gen},
+		scheduler.After[model.InstanceChange](time.Hour),
+		scheduler.Tag[model.InstanceChange](ins.Name),
+	)
+	return err
+}
+
+func (sh *schedulerHolder) Remove(ins model.Instance) error {
+	return sh.sched.UnscheduleByTag(ins.Name)
+}
+
+func newHolders(b *testing.B) map[string]func() holder {
+	return map[string]func() holder{
+		"heap": func() holder {
+			pih, err := pending_instances_holder.New(time.Hour, clock.New())
+			require.NoError(b, err)
+			return pih
+		},
+		"scheduler": func() holder {
+			sched, err := scheduler.NewScheduler[model.InstanceChange]()
+			require.NoError(b, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			b.Cleanup(cancel)
+			go func() { _ = sched.Start(ctx) }()
+
+			sh := &schedulerHolder{sched: sched}
+			probe := model.Instance{Name: "probe"}
+			require.Eventually(b, func() bool {
+				return sh.Add(probe, 0) == nil
+			}, time.Second, time.Millisecond)
+			require.NoError(b, sh.Remove(probe))
+
+			return sh
+		},
+	}
+}
+
+func newPendingInstances(n int) []model.Instance {
+	inses := make([]model.Instance, 0, n)
+	for idx := 0; idx < n; idx++ {
+		inses = append(inses, model.Instance{Name: "node" + strconv.Itoa(idx)})
+	}
+	return inses
+}
+
+// Adds and removes one instance, while n instances are pending.
+func BenchmarkAddRemove(b *testing.B) {
+	for _, n := range []int{1_000, 10_000} {
+		for name, newHolder := range newHolders(b) {
+			b.Run(name+"/"+strconv.Itoa(n), func(b *testing.B) {
+				h := newHolder()
+
+				// Scheduler deadlocks, if its head is removed, while it is updating its timer.
+				// Sentinel keeps the head in place.
+				require.NoError(b, h.Add(model.Instance{Name: "sentinel"}, 0))
+
+				inses := newPendingInstances(n)
+				for _, ins := range inses {
+					require.NoError(b, h.Add(ins, 1))
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					ins := inses[i%n]
+					_ = h.Remove(ins)
+					_ = h.Add(ins, uint64(i))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,20 @@
+module github.com/horockey/go-consul-instance-manager/internal/pending_instances_holder/schedulerbench
+
+go 1.21.3
+
+require (
+	github.com/horockey/go-consul-instance-manager v0.0.0
+	github.com/horockey/go-scheduler v1.0.2
+	github.com/stretchr/testify v1.8.4
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
+	github.com/google/uuid v1.3.1 // indirect
+	github.com/horockey/go-toolbox v1.5.0 // indirect
+	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
+	golang.org/x/exp v0.0.0-20230817173708-d852ddb80c63 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/horockey/go-consul-instance-manager => ../../..
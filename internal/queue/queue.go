@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"sync"
+)
+
+type Stats struct {
+	// Count of pending values.
+	Depth int
+	// Count of pushed values, merged into pending ones with the same key.
+	Coalesced uint64
+	// Count of pushed values, dropped because of full queue.
+	Dropped uint64
+}
+
+// FIFO queue, that coalesces values with the same key.
+// Push never blocks, so producer is not stalled by slow consumer.
+// Safe for concurrent use.
+type Queue[K comparable, V any] struct {
+	key   func(V) K
+	merge func(pending V, pushed V) V
+	limit int
+
+	mu      sync.Mutex
+	pending map[K]V
+	order   []K
+	stats   Stats
+
+	notify chan struct{}
+}
+
+// Creates queue, that holds at most limit values, zero limit means unlimited.
+// Pushed value is merged into pending value with the same key by merge func,
+// nil merge func replaces pending value with the pushed one.
+func New[K comparable, V any](limit int, key func(V) K, merge func(pending V, pushed V) V) *Queue[K, V] {
+	if merge == nil {
+		merge = func(_ V, pushed V) V { return pushed }
+	}
+
+	return &Queue[K, V]{
+		key:     key,
+		merge:   merge,
+		limit:   limit,
+		pending: map[K]V{},
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// Adds value to the queue or merges it into pending one with the same key.
+// Value is dropped, if queue is full, false is returned in this case.
+func (q *Queue[K, V]) Push(v V) bool {
+	k := q.key(v)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if pending, found := q.pending[k]; found {
+		q.pending[k] = q.merge(pending, v)
+		q.stats.Coalesced++
+		return true
+	}
+
+	if q.limit > 0 && len(q.order) >= q.limit {
+		q.stats.Dropped++
+		return false
+	}
+
+	q.pending[k] = v
+	q.order = append(q.order, k)
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// Takes the oldest pending value.
+func (q *Queue[K, V]) Pop() (V, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.order) == 0 {
+		var zero V
+		return zero, false
+	}
+
+	k := q.order[0]
+	q.order[0] = *new(K)
+	q.order = q.order[1:]
+
+	v := q.pending[k]
+	delete(q.pending, k)
+
+	return v, true
+}
+
+// Gets channel, that receives value, when there are pending values in the queue.
+// Single notification may stand for several values, so Pop must be called until queue is empty.
+func (q *Queue[K, V]) Notify() <-chan struct{} {
+	return q.notify
+}
+
+func (q *Queue[K, V]) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	st := q.stats
+	st.Depth = len(q.order)
+	return st
+}
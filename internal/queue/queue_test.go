@@ -0,0 +1,79 @@
+package queue_test
+
+import (
+	"testing"
+
+	"github.com/horockey/go-consul-instance-manager/internal/queue"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	key string
+	val int
+}
+
+func newQueue(limit int, merge func(item, item) item) *queue.Queue[string, item] {
+	return queue.New(limit, func(it item) string { return it.key }, merge)
+}
+
+func TestQueue_FIFO(t *testing.T) {
+	q := newQueue(0, nil)
+
+	_, ok := q.Pop()
+	require.False(t, ok)
+
+	require.True(t, q.Push(item{"a", 1}))
+	require.True(t, q.Push(item{"b", 2}))
+	require.True(t, q.Push(item{"c", 3}))
+
+	select {
+	case <-q.Notify():
+	default:
+		t.Fatal("queue did not notify about pending values")
+	}
+
+	for _, expected := range []item{{"a", 1}, {"b", 2}, {"c", 3}} {
+		it, ok := q.Pop()
+		require.True(t, ok)
+		require.Equal(t, expected, it)
+	}
+
+	_, ok = q.Pop()
+	require.False(t, ok)
+}
+
+func TestQueue_Coalescing(t *testing.T) {
+	q := newQueue(0, nil)
+
+	q.Push(item{"a", 1})
+	q.Push(item{"b", 2})
+	q.Push(item{"a", 3})
+
+	require.Equal(t, queue.Stats{Depth: 2, Coalesced: 1}, q.Stats())
+
+	// Coalesced value keeps position of the pending one.
+	it, _ := q.Pop()
+	require.Equal(t, item{"a", 3}, it)
+
+	sum := newQueue(0, func(pending item, pushed item) item {
+		return item{pending.key, pending.val + pushed.val}
+	})
+	sum.Push(item{"a", 1})
+	sum.Push(item{"a", 2})
+
+	it, _ = sum.Pop()
+	require.Equal(t, item{"a", 3}, it)
+}
+
+func TestQueue_Limit(t *testing.T) {
+	q := newQueue(2, nil)
+
+	require.True(t, q.Push(item{"a", 1}))
+	require.True(t, q.Push(item{"b", 2}))
+	require.False(t, q.Push(item{"c", 3}))
+
+	// Full queue still accepts values of pending keys.
+	require.True(t, q.Push(item{"b", 4}))
+
+	require.Equal(t, queue.Stats{Depth: 2, Coalesced: 1, Dropped: 1}, q.Stats())
+}
@@ -0,0 +1,222 @@
+package retrier
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/horockey/go-consul-instance-manager/clock"
+	"github.com/rs/zerolog"
+)
+
+// Returned without calling consul, while circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type Policy struct {
+	// Max count of attempts of every call, including the first one.
+	MaxAttempts uint
+	// Backoff before the second attempt, doubled for every next one.
+	InitialBackoff time.Duration
+	// Upper limit of backoff.
+	MaxBackoff time.Duration
+
+	// Count of consecutive failed attempts, after which circuit breaker is opened.
+	// Zero disables circuit breaker.
+	BreakerThreshold uint
+	// Duration, for which circuit breaker stays open before trial call is allowed.
+	BreakerCooldown time.Duration
+}
+
+type Metrics struct {
+	Calls         uint64
+	Attempts      uint64
+	Retries       uint64
+	Failures      uint64
+	Rejected      uint64
+	BreakerOpened uint64
+	BreakerOpen   bool
+}
+
+// Performs calls to consul according to retry policy
+// and protects consul with circuit breaker.
+// Safe for concurrent use.
+type Retrier struct {
+	policy Policy
+	clock  clock.Clock
+
+	mu        sync.Mutex
+	failures  uint
+	openUntil time.Time
+	trial     bool
+	metrics   Metrics
+
+	logger zerolog.Logger
+}
+
+func New(policy Policy, clk clock.Clock, logger zerolog.Logger) *Retrier {
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = 1
+	}
+
+	return &Retrier{
+		policy: policy,
+		clock:  clk,
+		logger: logger,
+	}
+}
+
+// Calls fn until it succeeds, attempts are exhausted or ctx is done.
+// Client errors of consul (4xx responses) are not retried.
+// name is used for logging only.
+func (r *Retrier) Do(ctx context.Context, name string, fn func() error) error {
+	r.mu.Lock()
+	r.metrics.Calls++
+	r.mu.Unlock()
+
+	backoff := r.policy.InitialBackoff
+
+	var err error
+	for attempt := uint(1); ; attempt++ {
+		if !r.allow() {
+			return errors.Join(err, ErrCircuitOpen)
+		}
+
+		err = fn()
+		r.done(err)
+		if err == nil || !retryable(err) || attempt >= r.policy.MaxAttempts {
+			break
+		}
+
+		wait := jitter(backoff)
+		r.logger.Warn().
+			Err(err).
+			Str("call", name).
+			Uint("attempt", attempt).
+			Dur("backoff", wait).
+			Msg("consul call failed, retrying")
+
+		timer := r.clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C():
+		}
+
+		r.mu.Lock()
+		r.metrics.Retries++
+		r.mu.Unlock()
+
+		backoff = min(backoff*2, r.policy.MaxBackoff)
+	}
+
+	return err
+}
+
+// Get snapshot of retrier metrics.
+func (r *Retrier) Metrics() Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := r.metrics
+	m.BreakerOpen = !r.openUntil.IsZero()
+	return m
+}
+
+// Closes circuit breaker and forgets failed attempts, e.g. on restart of the client.
+// Metrics are kept.
+func (r *Retrier) ResetBreaker() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures = 0
+	r.openUntil = time.Time{}
+	r.trial = false
+}
+
+// Reports whether attempt may be performed now.
+func (r *Retrier) allow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.openUntil.IsZero() {
+		return true
+	}
+
+	// Only one trial attempt is allowed after cooldown.
+	if r.trial || r.clock.Now().Before(r.openUntil) {
+		r.metrics.Rejected++
+		return false
+	}
+
+	r.trial = true
+	return true
+}
+
+// Registers result of the attempt.
+func (r *Retrier) done(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics.Attempts++
+	wasTrial := r.trial
+	r.trial = false
+
+	// Cancelled call tells nothing about consul health.
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	if err == nil || !retryable(err) {
+		if !r.openUntil.IsZero() {
+			r.logger.Info().Msg("consul circuit breaker is closed")
+		}
+		r.failures = 0
+		r.openUntil = time.Time{}
+		return
+	}
+
+	r.metrics.Failures++
+	r.failures++
+
+	if r.policy.BreakerThreshold == 0 || (!wasTrial && r.failures < r.policy.BreakerThreshold) {
+		return
+	}
+
+	if r.openUntil.IsZero() {
+		r.metrics.BreakerOpened++
+		r.logger.Error().
+			Err(err).
+			Uint("failures", r.failures).
+			Dur("cooldown", r.policy.BreakerCooldown).
+			Msg("consul circuit breaker is opened")
+	}
+	r.openUntil = r.clock.Now().Add(r.policy.BreakerCooldown)
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr consul.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= http.StatusInternalServerError || statusErr.Code == http.StatusTooManyRequests
+	}
+
+	return true
+}
+
+// Picks random duration in [d/2, d).
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)))
+}
@@ -0,0 +1,152 @@
+package retrier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/horockey/go-consul-instance-manager/clock/clocktest"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+var errConsul = errors.New("consul is unavailable")
+
+// Calls r.Do with fn, advancing clk by maxBackoff for every retry.
+func doWithBackoffs(r *Retrier, clk *clocktest.Clock, maxBackoff time.Duration, retries int, fn func() error) error {
+	errs := make(chan error, 1)
+	go func() { errs <- r.Do(context.TODO(), "test", fn) }()
+
+	for i := 0; i < retries; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(maxBackoff)
+	}
+
+	return <-errs
+}
+
+func TestDo_RetriesUntilSuccess(t *testing.T) {
+	clk := clocktest.New(time.Now())
+	r := New(Policy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond * 10,
+		MaxBackoff:     time.Millisecond * 20,
+	}, clk, zerolog.Nop())
+
+	calls := 0
+	err := doWithBackoffs(r, clk, time.Millisecond*20, 2, func() error {
+		calls++
+		if calls < 3 {
+			return errConsul
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	require.Equal(t, Metrics{
+		Calls:    1,
+		Attempts: 3,
+		Retries:  2,
+		Failures: 2,
+	}, r.Metrics())
+
+	calls = 0
+	err = doWithBackoffs(r, clk, time.Millisecond*20, 2, func() error {
+		calls++
+		return errConsul
+	})
+	require.ErrorIs(t, err, errConsul)
+	require.Equal(t, 3, calls)
+}
+
+func TestDo_ClientErrorNotRetried(t *testing.T) {
+	r := New(Policy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond * 10,
+		MaxBackoff:     time.Millisecond * 20,
+	}, clocktest.New(time.Now()), zerolog.Nop())
+
+	calls := 0
+	err := r.Do(context.TODO(), "test", func() error {
+		calls++
+		return consul.StatusError{Code: http.StatusForbidden}
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+}
+
+func TestDo_CircuitBreaker(t *testing.T) {
+	cooldown := time.Millisecond * 100
+	clk := clocktest.New(time.Now())
+	r := New(Policy{
+		BreakerThreshold: 2,
+		BreakerCooldown:  cooldown,
+	}, clk, zerolog.Nop())
+
+	failing := func() error { return errConsul }
+	calls := 0
+	healthy := func() error {
+		calls++
+		return nil
+	}
+
+	require.ErrorIs(t, r.Do(context.TODO(), "test", failing), errConsul)
+	require.False(t, r.Metrics().BreakerOpen)
+	require.ErrorIs(t, r.Do(context.TODO(), "test", failing), errConsul)
+	require.True(t, r.Metrics().BreakerOpen)
+
+	require.ErrorIs(t, r.Do(context.TODO(), "test", healthy), ErrCircuitOpen)
+	require.Zero(t, calls)
+
+	clk.Advance(cooldown - 1)
+	require.ErrorIs(t, r.Do(context.TODO(), "test", healthy), ErrCircuitOpen)
+	require.Zero(t, calls)
+
+	// Failed trial opens breaker again.
+	clk.Advance(1)
+	require.ErrorIs(t, r.Do(context.TODO(), "test", failing), errConsul)
+	require.ErrorIs(t, r.Do(context.TODO(), "test", healthy), ErrCircuitOpen)
+
+	clk.Advance(cooldown)
+	require.NoError(t, r.Do(context.TODO(), "test", healthy))
+	require.Equal(t, 1, calls)
+
+	m := r.Metrics()
+	require.False(t, m.BreakerOpen)
+	require.Equal(t, uint64(1), m.BreakerOpened)
+	require.Equal(t, uint64(3), m.Rejected)
+}
+
+func TestResetBreaker(t *testing.T) {
+	r := New(Policy{
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Hour,
+	}, clocktest.New(time.Now()), zerolog.Nop())
+
+	require.ErrorIs(t, r.Do(context.TODO(), "test", func() error { return errConsul }), errConsul)
+	require.True(t, r.Metrics().BreakerOpen)
+
+	r.ResetBreaker()
+	require.False(t, r.Metrics().BreakerOpen)
+	require.NoError(t, r.Do(context.TODO(), "test", func() error { return nil }))
+	require.Equal(t, uint64(2), r.Metrics().Calls)
+}
+
+func TestDo_ContextDone(t *testing.T) {
+	r := New(Policy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Hour,
+		MaxBackoff:     time.Hour,
+	}, clocktest.New(time.Now()), zerolog.Nop())
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*50)
+	defer cancel()
+
+	err := r.Do(ctx, "test", func() error { return errConsul })
+	require.ErrorIs(t, err, errConsul)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
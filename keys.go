@@ -0,0 +1,98 @@
+package go_consul_instance_manager
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"unsafe"
+)
+
+const keyBufSize = 64
+
+// Set of types, that can be used as data keys.
+// Typed key is always placed the same way as its string form,
+// e.g. uint64(42) is held by the same instances as "42".
+type Key interface {
+	~string | ~[]byte |
+		~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// Custom data key.
+// AppendKey must append string form of the key to dst and return the extended slice.
+type KeyEncoder interface {
+	AppendKey(dst []byte) []byte
+}
+
+// Get list of instances that hold given typed key.
+// Client must be started to run this method properly.
+func GetDataHoldersOf[K Key](cl *Client, key K) ([]*Instance, error) {
+	var buf [keyBufSize]byte
+	return cl.getDataHolders(bytesToString(appendKey(buf[:0], key)))
+}
+
+// Get instances that hold given typed keys, grouped by instance.
+// See Client.GetDataHoldersBatch for details.
+func GetDataHoldersBatchOf[K Key](cl *Client, keys []K) (map[*Instance][]K, error) {
+	return getDataHoldersBatch(cl, keys, appendKey[K])
+}
+
+// Get list of instances that hold given custom key.
+// Client must be started to run this method properly.
+func GetDataHoldersOfEncoder[K KeyEncoder](cl *Client, key K) ([]*Instance, error) {
+	var buf [keyBufSize]byte
+	return cl.getDataHolders(bytesToString(appendEncodedKey(buf[:0], key)))
+}
+
+// Get instances that hold given custom keys, grouped by instance.
+// See Client.GetDataHoldersBatch for details.
+func GetDataHoldersBatchOfEncoder[K KeyEncoder](cl *Client, keys []K) (map[*Instance][]K, error) {
+	return getDataHoldersBatch(cl, keys, appendEncodedKey[K])
+}
+
+func appendEncodedKey[K KeyEncoder](dst []byte, key K) []byte {
+	return key.AppendKey(dst)
+}
+
+// Appends string form of the key by its underlying kind.
+// Kind is taken from the static type of K, so the key itself is not boxed
+// and is read in place as its underlying type, named types included.
+func appendKey[K Key](dst []byte, key K) []byte {
+	p := unsafe.Pointer(&key)
+
+	switch reflect.TypeOf((*K)(nil)).Elem().Kind() {
+	case reflect.String:
+		return append(dst, *(*string)(p)...)
+	case reflect.Slice:
+		return append(dst, *(*[]byte)(p)...)
+	case reflect.Int:
+		return strconv.AppendInt(dst, int64(*(*int)(p)), 10)
+	case reflect.Int8:
+		return strconv.AppendInt(dst, int64(*(*int8)(p)), 10)
+	case reflect.Int16:
+		return strconv.AppendInt(dst, int64(*(*int16)(p)), 10)
+	case reflect.Int32:
+		return strconv.AppendInt(dst, int64(*(*int32)(p)), 10)
+	case reflect.Int64:
+		return strconv.AppendInt(dst, *(*int64)(p), 10)
+	case reflect.Uint:
+		return strconv.AppendUint(dst, uint64(*(*uint)(p)), 10)
+	case reflect.Uint8:
+		return strconv.AppendUint(dst, uint64(*(*uint8)(p)), 10)
+	case reflect.Uint16:
+		return strconv.AppendUint(dst, uint64(*(*uint16)(p)), 10)
+	case reflect.Uint32:
+		return strconv.AppendUint(dst, uint64(*(*uint32)(p)), 10)
+	case reflect.Uint64:
+		return strconv.AppendUint(dst, *(*uint64)(p), 10)
+	default:
+		// Unreachable while Key constraint and this switch are in sync.
+		panic(fmt.Sprintf("unsupported key kind: %s", reflect.TypeOf((*K)(nil)).Elem().Kind()))
+	}
+}
+
+// Hashring does not retain given keys,
+// so key buffer may be passed to it without copying.
+func bytesToString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
@@ -0,0 +1,154 @@
+package go_consul_instance_manager
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/horockey/go-consul-instance-manager/clock"
+	"github.com/rs/zerolog"
+	"github.com/serialx/hashring"
+	"github.com/stretchr/testify/require"
+)
+
+type userID uint64
+
+type userName string
+
+type shardID int16
+
+type encodedKey struct {
+	prefix string
+	id     int
+}
+
+func (k encodedKey) AppendKey(dst []byte) []byte {
+	dst = append(dst, k.prefix...)
+	return strconv.AppendInt(dst, int64(k.id), 10)
+}
+
+func newTestClient(names ...string) *Client {
+	cl := &Client{
+		instances:    map[string]*Instance{},
+		hashrings:    []*hashring.HashRing{hashring.New([]string{})},
+		hashFuncs:    []hashring.HashFunc{nil},
+		clock:        clock.New(),
+		eventHandler: func(Event) {},
+		ready:        make(chan struct{}),
+		logger:       zerolog.Nop(),
+	}
+	for _, name := range names {
+		cl.instances[name] = &Instance{name: name, status: InstanceStatusAlive}
+		cl.hashrings[0] = cl.hashrings[0].AddNode(name)
+	}
+
+	return cl
+}
+
+func TestAppendKey(t *testing.T) {
+	testCases := []struct {
+		name     string
+		appended []byte
+		expected string
+	}{
+		{"string", appendKey(nil, "abc"), "abc"},
+		{"bytes", appendKey(nil, []byte("abc")), "abc"},
+		{"int", appendKey(nil, -42), "-42"},
+		{"int8", appendKey(nil, int8(-8)), "-8"},
+		{"uint64", appendKey(nil, uint64(18446744073709551615)), "18446744073709551615"},
+		{"uint8", appendKey(nil, uint8(255)), "255"},
+		{"named uint64", appendKey(nil, userID(42)), "42"},
+		{"named string", appendKey(nil, userName("abc")), "abc"},
+		{"named int16", appendKey(nil, shardID(-16)), "-16"},
+		{"encoder", appendEncodedKey(nil, encodedKey{"user_", 42}), "user_42"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, string(tc.appended))
+		})
+	}
+}
+
+func TestGetDataHoldersOf_SamePlacement(t *testing.T) {
+	cl := newTestClient("host1", "host2", "host3")
+
+	for id := uint64(0); id < 1000; id++ {
+		expected, err := cl.GetDataHolders(strconv.FormatUint(id, 10))
+		require.NoError(t, err)
+
+		holders, err := GetDataHoldersOf(cl, id)
+		require.NoError(t, err)
+		require.Equal(t, expected, holders)
+
+		holders, err = GetDataHoldersOf(cl, userID(id))
+		require.NoError(t, err)
+		require.Equal(t, expected, holders)
+
+		holders, err = GetDataHoldersOfEncoder(cl, encodedKey{"", int(id)})
+		require.NoError(t, err)
+		require.Equal(t, expected, holders)
+	}
+}
+
+func TestGetDataHoldersOf_NoKeyAllocs(t *testing.T) {
+	cl := newTestClient("host1", "host2", "host3")
+
+	key := strconv.FormatUint(123456, 10)
+	expected := testing.AllocsPerRun(100, func() {
+		_, _ = cl.GetDataHolders(key)
+	})
+
+	// Typed key costs nothing beyond lookup of its formatted form.
+	require.Equal(t, expected, testing.AllocsPerRun(100, func() {
+		_, _ = GetDataHoldersOf(cl, uint64(123456))
+	}))
+	require.Equal(t, expected, testing.AllocsPerRun(100, func() {
+		_, _ = GetDataHoldersOf(cl, userID(123456))
+	}))
+}
+
+func TestGetDataHoldersBatchOf_SamePlacement(t *testing.T) {
+	cl := newTestClient("host1", "host2", "host3")
+
+	ids := make([]uint64, 1000)
+	for idx := range ids {
+		ids[idx] = uint64(idx)
+	}
+
+	plan, err := GetDataHoldersBatchOf(cl, ids)
+	require.NoError(t, err)
+
+	total := 0
+	for ins, group := range plan {
+		for _, id := range group {
+			holders, err := cl.GetDataHolders(fmt.Sprint(id))
+			require.NoError(t, err)
+			require.Equal(t, []*Instance{ins}, holders)
+		}
+		total += len(group)
+	}
+	require.Equal(t, len(ids), total)
+}
+
+func BenchmarkGetDataHoldersOf(b *testing.B) {
+	cl := newTestClient("host1", "host2", "host3")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetDataHoldersOf(cl, uint64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetDataHolders_FormattedKey(b *testing.B) {
+	cl := newTestClient("host1", "host2", "host3")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := cl.GetDataHolders(strconv.FormatUint(uint64(i), 10)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
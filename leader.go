@@ -0,0 +1,52 @@
+package go_consul_instance_manager
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Get current leader among instances of the app.
+// Leader election must be enabled with WithLeaderElection.
+// Client must be started to run this method properly.
+func (cl *Client) Leader() (*Instance, error) {
+	if !cl.leaderElection {
+		return nil, errors.New("leader election is not enabled, see WithLeaderElection")
+	}
+
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	if cl.leader == "" {
+		return nil, errors.New("leader is not elected")
+	}
+
+	ins, found := cl.instances[cl.leader]
+	if !found {
+		return nil, fmt.Errorf("unknown leader instance: %s", cl.leader)
+	}
+
+	return ins, nil
+}
+
+// Reports whether local instance is the leader now.
+func (cl *Client) IsLeader() bool {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	return cl.self != "" && cl.leader == cl.self
+}
+
+func (cl *Client) setLeader(leader string) {
+	cl.mu.Lock()
+	wasLeader := cl.self != "" && cl.leader == cl.self
+	cl.leader = leader
+	isLeader := cl.self != "" && cl.leader == cl.self
+	cl.mu.Unlock()
+
+	switch {
+	case !wasLeader && isLeader:
+		cl.onLeaderGained()
+	case wasLeader && !isLeader:
+		cl.onLeaderLost()
+	}
+}
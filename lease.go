@@ -0,0 +1,130 @@
+package go_consul_instance_manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// Lease was lost, before its fencing token was read.
+var errLeaseLost = errors.New("lease is lost")
+
+// Ownership lease for a range of key hashes.
+// Only one instance can hold lease for the range at a time.
+type Lease struct {
+	partition int
+	token     uint64
+	held      *heldLock
+}
+
+// Get index of hash range (lease partition), that contains given key.
+// Key space is split into equal ranges, count of ranges is set by WithLeasePartitions.
+func (cl *Client) LeasePartition(key string) int {
+	return int(uint64(crc32.ChecksumIEEE([]byte(key))) * uint64(cl.leasePartitions) >> 32)
+}
+
+// Acquires ownership lease for given hash range.
+// Blocks until previous owner releases the lease or its session is invalidated, or ctx is done.
+// Every acquisition of the lease gets greater fencing token, than all previous ones,
+// so storage may reject writes of stale owners.
+// WithSelf is required to let other instances know lease holder.
+func (cl *Client) AcquireLease(ctx context.Context, partition int) (*Lease, error) {
+	if cl.self == "" {
+		return nil, errSelfNotSet
+	}
+
+	if partition < 0 || partition >= int(cl.leasePartitions) {
+		return nil, fmt.Errorf("lease partition must be in [0, %d), got: %d", cl.leasePartitions, partition)
+	}
+
+	kvKey := cl.leaseKey(partition)
+
+	for {
+		held, err := cl.acquireLock(ctx, kvKey, "lease")
+		if err != nil {
+			return nil, err
+		}
+
+		token, err := cl.getFencingToken(ctx, kvKey, held)
+		if err == nil {
+			return &Lease{
+				partition: partition,
+				token:     token,
+				held:      held,
+			}, nil
+		}
+
+		_ = held.release()
+		if !errors.Is(err, errLeaseLost) {
+			return nil, fmt.Errorf("getting fencing token: %w", err)
+		}
+		// Somebody else may own the lease now, so it is acquired again.
+	}
+}
+
+// Gets fencing token of just acquired lease.
+// LockIndex is incremented by consul on every successful acquisition of the key,
+// so it is used as fencing token, if the key is still held by session of the lease.
+func (cl *Client) getFencingToken(ctx context.Context, kvKey string, held *heldLock) (uint64, error) {
+	var pair *consul.KVPair
+	err := cl.retrier.Do(ctx, "kv get", func() error {
+		var err error
+		pair, _, err = cl.cl.KV().Get(kvKey, (&consul.QueryOptions{RequireConsistent: true}).WithContext(ctx))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if pair == nil || pair.Session != held.session {
+		return 0, errLeaseLost
+	}
+
+	select {
+	case <-held.lost:
+		return 0, errLeaseLost
+	default:
+	}
+
+	return pair.LockIndex, nil
+}
+
+// Get instance, that holds ownership lease for given hash range now.
+// If nobody holds the lease, ErrNotLocked is returned.
+func (cl *Client) LeaseHolder(partition int) (*Instance, error) {
+	return cl.LeaseHolderContext(context.Background(), partition)
+}
+
+// Same as LeaseHolder, but retries of the call are bounded by ctx.
+func (cl *Client) LeaseHolderContext(ctx context.Context, partition int) (*Instance, error) {
+	return cl.getLockHolder(ctx, cl.leaseKey(partition))
+}
+
+func (cl *Client) leaseKey(partition int) string {
+	return cl.appName + "/leases/" + strconv.Itoa(partition)
+}
+
+func (l *Lease) Partition() int {
+	return l.partition
+}
+
+// Fencing token of the lease.
+// It is monotonically increasing among all acquisitions of the same hash range.
+func (l *Lease) Token() uint64 {
+	return l.token
+}
+
+// Closed, when lease is lost, e.g. due to session invalidation.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.held.lost
+}
+
+// Releases the lease, letting new owner to acquire it.
+// It is no-op, if lease is already lost.
+func (l *Lease) Release() error {
+	return l.held.release()
+}
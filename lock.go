@@ -0,0 +1,339 @@
+package go_consul_instance_manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+var ErrNotLocked = errors.New("lock is not held by anyone")
+
+// Distributed lock, held by local instance.
+type Lock struct {
+	key  string
+	held *heldLock
+}
+
+// Consul lock, held with own session of the client.
+type heldLock struct {
+	cl      *Client
+	key     string
+	session string
+	lost    chan struct{}
+
+	// Stops watching of the key.
+	stopWatch context.CancelFunc
+	// Stops renewal of the session and destroys it.
+	stopRenew   context.CancelFunc
+	releaseOnce sync.Once
+}
+
+// Acquires distributed lock for given key.
+// Blocks until lock is acquired or ctx is done.
+// Lock is built on consul session, so it is released automatically,
+// if session is invalidated (e.g. when local process dies).
+// WithSelf is required to let other instances know lock holder.
+func (cl *Client) Lock(ctx context.Context, key string) (*Lock, error) {
+	if cl.self == "" {
+		return nil, errSelfNotSet
+	}
+
+	held, err := cl.acquireLock(ctx, cl.lockKey(key), "lock")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lock{
+		key:  key,
+		held: held,
+	}, nil
+}
+
+// Get instance, that holds distributed lock for given key now.
+// If nobody holds the lock, ErrNotLocked is returned.
+func (cl *Client) LockHolder(key string) (*Instance, error) {
+	return cl.LockHolderContext(context.Background(), key)
+}
+
+// Same as LockHolder, but retries of the call are bounded by ctx.
+func (cl *Client) LockHolderContext(ctx context.Context, key string) (*Instance, error) {
+	return cl.getLockHolder(ctx, cl.lockKey(key))
+}
+
+func (cl *Client) getLockHolder(ctx context.Context, kvKey string) (*Instance, error) {
+	var pair *consul.KVPair
+	err := cl.retrier.Do(ctx, "kv get", func() error {
+		var err error
+		pair, _, err = cl.cl.KV().Get(kvKey, (&consul.QueryOptions{}).WithContext(ctx))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting lock key: %w", err)
+	}
+
+	if pair == nil || pair.Session == "" {
+		return nil, ErrNotLocked
+	}
+
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	ins, found := cl.instances[string(pair.Value)]
+	if !found {
+		return nil, fmt.Errorf("unknown lock holder instance: %s", string(pair.Value))
+	}
+
+	return ins, nil
+}
+
+// Acquires consul lock of given key with new session.
+// Session is created by the client, so holder of the key may be compared with it.
+// Every call to consul is bound by ctx, so cancellation is seen at once.
+func (cl *Client) acquireLock(
+	ctx context.Context,
+	kvKey string,
+	kind string,
+) (*heldLock, error) {
+	var session string
+	err := cl.retrier.Do(ctx, "session create", func() error {
+		var err error
+		session, _, err = cl.cl.Session().Create(&consul.SessionEntry{
+			Name:      cl.appName + "_" + cl.self + "_" + kind,
+			TTL:       cl.sessionTTL.String(),
+			LockDelay: cl.lockDelay,
+			Behavior:  consul.SessionBehaviorRelease,
+		}, (&consul.WriteOptions{}).WithContext(ctx))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating consul session: %w", err)
+	}
+
+	// Session is destroyed, when renewal is stopped.
+	renewCtx, stopRenew := context.WithCancel(context.Background())
+	go cl.renewLockSession(renewCtx, session)
+
+	waitIdx, err := cl.waitLock(ctx, kvKey, session)
+	if err != nil {
+		stopRenew()
+		return nil, fmt.Errorf("acquiring consul lock: %w", err)
+	}
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	held := &heldLock{
+		cl:        cl,
+		key:       kvKey,
+		session:   session,
+		lost:      make(chan struct{}),
+		stopWatch: stopWatch,
+		stopRenew: stopRenew,
+	}
+
+	go func() {
+		held.watch(watchCtx, waitIdx)
+		// Stop session renewal, if lock is lost.
+		_ = held.release()
+	}()
+
+	return held, nil
+}
+
+// Renews session of the lock until ctx is done, then destroys it.
+// Renewal is given up, if session is gone or can not be renewed within its TTL,
+// loss of the lock is detected by watch of its key then.
+func (cl *Client) renewLockSession(ctx context.Context, session string) {
+	defer cl.destroyLockSession(session)
+
+	ticker := cl.clock.NewTicker(cl.sessionTTL / 2)
+	defer ticker.Stop()
+
+	lastRenew := cl.clock.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			var entry *consul.SessionEntry
+			err := cl.retrier.Do(ctx, "session renew", func() error {
+				var err error
+				entry, _, err = cl.cl.Session().Renew(session, (&consul.WriteOptions{}).WithContext(ctx))
+				return err
+			})
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				if cl.clock.Now().Sub(lastRenew) >= cl.sessionTTL {
+					cl.logger.Error().
+						Err(fmt.Errorf("renewing lock session: %w", err)).
+						Send()
+					return
+				}
+				cl.logger.Warn().
+					Err(fmt.Errorf("renewing lock session: %w", err)).
+					Send()
+			case entry == nil:
+				return
+			default:
+				lastRenew = cl.clock.Now()
+			}
+		}
+	}
+}
+
+func (cl *Client) destroyLockSession(session string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cl.sessionTTL/2)
+	defer cancel()
+
+	err := cl.retrier.Do(ctx, "session destroy", func() error {
+		_, err := cl.cl.Session().Destroy(session, (&consul.WriteOptions{}).WithContext(ctx))
+		return err
+	})
+	if err != nil {
+		cl.logger.Error().
+			Err(fmt.Errorf("destroying lock session: %w", err)).
+			Send()
+	}
+}
+
+// Waits until key is free and acquires it with given session.
+// Returns index of the key after acquisition.
+func (cl *Client) waitLock(ctx context.Context, kvKey string, session string) (uint64, error) {
+	var waitIdx uint64
+	for {
+		var (
+			pair *consul.KVPair
+			meta *consul.QueryMeta
+		)
+		err := cl.retrier.Do(ctx, "kv get", func() error {
+			var err error
+			pair, meta, err = cl.cl.KV().Get(kvKey, (&consul.QueryOptions{
+				WaitIndex: waitIdx,
+			}).WithContext(ctx))
+			return err
+		})
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if err != nil {
+			return 0, fmt.Errorf("getting lock key: %w", err)
+		}
+		waitIdx = meta.LastIndex
+
+		if pair != nil && pair.Session != "" {
+			if pair.Session == session {
+				return waitIdx, nil
+			}
+			// Wait for the holder to release the key.
+			continue
+		}
+
+		var acquired bool
+		err = cl.retrier.Do(ctx, "kv acquire", func() error {
+			var err error
+			acquired, _, err = cl.cl.KV().Acquire(&consul.KVPair{
+				Key:     kvKey,
+				Value:   []byte(cl.self),
+				Flags:   consul.LockFlagValue,
+				Session: session,
+			}, (&consul.WriteOptions{}).WithContext(ctx))
+			return err
+		})
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if err != nil {
+			return 0, fmt.Errorf("acquiring lock key: %w", err)
+		}
+		// Key is read again without waiting: to watch it from acquisition,
+		// or to retry acquisition, if key is still free.
+		waitIdx = 0
+		if acquired {
+			continue
+		}
+
+		// Free key is not acquired during lock delay of its previous holder,
+		// so acquisition is retried after a while, like consul.Lock does.
+		timer := cl.clock.NewTimer(consul.DefaultLockRetryTime)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// Watches the key and closes lost, when session no longer holds it.
+// Failed watch is treated as loss, since the lock can not be confirmed.
+func (hl *heldLock) watch(ctx context.Context, waitIdx uint64) {
+	defer close(hl.lost)
+
+	for {
+		var (
+			pair *consul.KVPair
+			meta *consul.QueryMeta
+		)
+		err := hl.cl.retrier.Do(ctx, "kv get", func() error {
+			var err error
+			pair, meta, err = hl.cl.cl.KV().Get(hl.key, (&consul.QueryOptions{
+				WaitIndex: waitIdx,
+			}).WithContext(ctx))
+			return err
+		})
+		if err != nil || pair == nil || pair.Session != hl.session {
+			return
+		}
+		waitIdx = meta.LastIndex
+	}
+}
+
+// Releases the lock and destroys its session.
+// It is no-op, if lock is already lost.
+func (hl *heldLock) release() error {
+	var err error
+	hl.releaseOnce.Do(func() {
+		hl.stopWatch()
+		defer hl.stopRenew()
+
+		ctx, cancel := context.WithTimeout(context.Background(), hl.cl.sessionTTL)
+		defer cancel()
+
+		// Released key is free at once, unlike key of destroyed session, that is kept for lock delay.
+		err = hl.cl.retrier.Do(ctx, "kv release", func() error {
+			_, _, err := hl.cl.cl.KV().Release(&consul.KVPair{
+				Key:     hl.key,
+				Flags:   consul.LockFlagValue,
+				Session: hl.session,
+			}, (&consul.WriteOptions{}).WithContext(ctx))
+			return err
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("releasing consul lock: %w", err)
+	}
+
+	return nil
+}
+
+func (cl *Client) lockKey(key string) string {
+	return cl.appName + "/locks/" + key
+}
+
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Closed, when lock is lost, e.g. due to session invalidation.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.held.lost
+}
+
+// Releases the lock.
+// It is no-op, if lock is already lost.
+func (l *Lock) Unlock() error {
+	return l.held.release()
+}
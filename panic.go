@@ -0,0 +1,40 @@
+package go_consul_instance_manager
+
+// Reports whether client is in panic mode.
+// In panic mode topology is frozen: no instances are marked pending or evicted.
+// Panic mode must be enabled with WithPanicMode.
+func (cl *Client) Panicking() bool {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	return cl.panicking
+}
+
+func (cl *Client) handlePanic(panicking bool) {
+	cl.mu.Lock()
+	changed := cl.panicking != panicking
+	cl.panicking = panicking
+	cl.mu.Unlock()
+
+	// Panic state may be reported again, if its change was not received in time.
+	if !changed {
+		return
+	}
+
+	if panicking {
+		cl.logger.Warn().Msg("consul responses are not trusted, topology is frozen")
+		cl.eventHandler(Event{Type: EventTypePanicStarted})
+		return
+	}
+
+	// Changes of the scan, that ended panic, are sent before the signal.
+	cl.drainChanges()
+
+	// Evictions are resumed with generation check,
+	// so instances, that came back during panic, are not evicted.
+	cl.applyTimerInputs(cl.deferredEvictions, instanceInputHoldExpired)
+	cl.deferredEvictions = nil
+
+	cl.logger.Info().Msg("consul looks healthy again, topology is unfrozen")
+	cl.eventHandler(Event{Type: EventTypePanicEnded})
+}
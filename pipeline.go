@@ -0,0 +1,51 @@
+package go_consul_instance_manager
+
+import (
+	"github.com/horockey/go-consul-instance-manager/internal/queue"
+)
+
+// Stats of internal queue of the client.
+// Queues never drop values, they are either unbounded or coalescing.
+type QueueStats struct {
+	// Count of pending values.
+	Depth int
+	// Count of values, coalesced with pending ones.
+	Coalesced uint64
+}
+
+// Stats of internal event pipeline of the client.
+type PipelineStats struct {
+	// Queue of changes, found by scans of consul.
+	// Change sets of scans are coalesced into one.
+	Changes QueueStats
+	// Queue of expired holds of pending instances.
+	Evictions QueueStats
+	// Queue of expired retentions of dead instances.
+	Purges QueueStats
+}
+
+// Get stats of internal event pipeline.
+// Stats are reset on every start of the client.
+// Zero stats are returned for client, that was never started.
+func (cl *Client) PipelineStats() PipelineStats {
+	cl.lifecycleMu.Lock()
+	hc, pih, tombstones := cl.healthChecker, cl.pih, cl.tombstones
+	cl.lifecycleMu.Unlock()
+
+	if hc == nil {
+		return PipelineStats{}
+	}
+
+	return PipelineStats{
+		Changes:   queueStats(hc.Out().Stats()),
+		Evictions: queueStats(pih.Out().Stats()),
+		Purges:    queueStats(tombstones.Out().Stats()),
+	}
+}
+
+func queueStats(st queue.Stats) QueueStats {
+	return QueueStats{
+		Depth:     st.Depth,
+		Coalesced: st.Coalesced,
+	}
+}
@@ -0,0 +1,62 @@
+package go_consul_instance_manager
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Returned by lookups, when topology is older than allowed (see WithMaxStaleness).
+var ErrStaleTopology = errors.New("topology is stale")
+
+// Status of synchronization of the client with consul.
+type SyncStatus struct {
+	// Time of the last scan of consul, that was applied to topology.
+	// Zero, if there was no such scan since the client start.
+	LastSync time.Time
+	// Last error of the scan. It is not reset by successful scans.
+	LastError error
+	// Count of failed scans since the last successful one.
+	ConsecutiveFailures uint
+	// Consul index of the last successful scan.
+	Index uint64
+}
+
+// Get status of synchronization with consul.
+// Zero status is returned for client, that was never started.
+func (cl *Client) SyncStatus() SyncStatus {
+	cl.lifecycleMu.Lock()
+	hc := cl.healthChecker
+	cl.lifecycleMu.Unlock()
+
+	if hc == nil {
+		return SyncStatus{}
+	}
+
+	st := hc.SyncStatus()
+	return SyncStatus{
+		LastSync:            st.LastSync,
+		LastError:           st.LastError,
+		ConsecutiveFailures: st.ConsecutiveFailures,
+		Index:               st.Index,
+	}
+}
+
+// Returns ErrStaleTopology, if max staleness is set and topology is older.
+// Topology is not considered stale before the first sync.
+func (cl *Client) checkStaleness() error {
+	if cl.maxStaleness == 0 {
+		return nil
+	}
+
+	lastSync := cl.SyncStatus().LastSync
+	if lastSync.IsZero() {
+		return nil
+	}
+
+	if age := cl.clock.Now().Sub(lastSync); age > cl.maxStaleness {
+		return fmt.Errorf("%w: last sync was %s ago", ErrStaleTopology, age.Truncate(time.Millisecond))
+	}
+
+	return nil
+}